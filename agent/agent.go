@@ -2,19 +2,20 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	stderrors "errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
 	hlow "nikand.dev/go/hacked/low"
 	"tlog.app/go/eazy"
 	"tlog.app/go/errors"
-	"tlog.app/go/loc"
 
 	"tlog.app/go/tlog"
 	"tlog.app/go/tlog/tlwire"
@@ -24,15 +25,12 @@ type (
 	Agent struct {
 		path string
 
-		mu sync.Mutex
-
-		subid int64 // last used
-		subs  []sub
-
+		mu      sync.Mutex
 		streams []*stream
-		//	files   []*file
 
-		// end of mu
+		submu sync.Mutex
+		subid int64 // last used
+		subs  []*sub
 
 		KeyTimestamp string
 
@@ -40,50 +38,107 @@ type (
 		FileSize  int64
 		BlockSize int64
 
-		Stderr io.Writer
+		// Retention. Zero means no limit.
+		MaxTotalSize  int64
+		MaxTotalAge   time.Duration
+		MaxStreamSize int64 // per label set
+
+		CompactFileSize int64 // merge files smaller than that in closed partitions
+		CleanupInterval time.Duration
+
+		Sync       SyncPolicy
+		SyncPeriod time.Duration
+
+		// Logger receives agent own messages and metrics every MetricsInterval.
+		// New files are not logged, they are counted in StreamStats.Files.
+		Logger          *tlog.Logger
+		MetricsInterval time.Duration
+
+		SubQueueSize   int
+		SlowSubscriber SlowPolicy
+
+		// MaxTraceDuration bounds Trace scan after the first trace event. Zero means no limit.
+		MaxTraceDuration time.Duration
 
-		d tlwire.Decoder
+		Stderr io.Writer
 	}
 
 	stream struct {
 		labels []byte
 		sum    uint32
 
+		mu sync.Mutex
+
 		file *file
 
 		z    *eazy.Writer
 		zbuf hlow.Buf
 		boff int64
+
+		newBlock bool
+
+		stats StreamStats
 	}
 
 	file struct {
-		w io.Writer
+		w   io.Writer
+		idx io.Writer
 
 		name string
 
 		part int64
 		ts   int64
 
-		prev *file
-
 		mu sync.Mutex
 
 		off   int64
 		index []ientry
+		dirty bool // not synced
 	}
 
 	ientry struct {
 		off int64
 		ts  int64
 	}
+
+	// SyncPolicy defines when written data is flushed to the disk.
+	SyncPolicy int
+)
+
+// Sync policies.
+const (
+	// SyncInterval syncs written files every SyncPeriod.
+	SyncInterval SyncPolicy = iota
+
+	// SyncAlways syncs the file after each event.
+	SyncAlways
+
+	// SyncNever leaves it to the OS.
+	SyncNever
 )
 
 var (
 	ErrUnknownSubscription = stderrors.New("unknown subscription")
 
 	ErrFileFull = stderrors.New("file is full")
+
+	ErrSlowSubscriber = stderrors.New("slow subscriber")
 )
 
+// ParseSyncPolicy parses policy name: always, interval, or never.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "interval":
+		return SyncInterval, nil
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	}
+
+	return 0, errors.New("unsupported sync policy: %q", s)
+}
+
 func New(path string) (*Agent, error) {
 	a := &Agent{
 		path: path,
@@ -93,42 +148,167 @@ func New(path string) (*Agent, error) {
 		FileSize:     eazy.GiB,
 		BlockSize:    16 * eazy.MiB,
 
+		CleanupInterval: time.Minute,
+
+		Sync:       SyncInterval,
+		SyncPeriod: time.Second,
+
+		Logger:          tlog.DefaultLogger,
+		MetricsInterval: time.Minute,
+
+		SubQueueSize: 1024,
+
+		MaxTraceDuration: 24 * time.Hour,
+
 		Stderr: os.Stderr,
 	}
 
+	err := a.load()
+	if err != nil {
+		return nil, errors.Wrap(err, "load db")
+	}
+
 	return a, nil
 }
 
+// Write stores events from p.
+// Streams with different labels are compressed and written concurrently,
+// events within a stream are written in order.
 func (a *Agent) Write(p []byte) (n int, err error) {
-	defer a.mu.Unlock()
-	a.mu.Lock()
-
 	for n < len(p) {
-		ts, labels, err := a.parseEventHeader(p[n:])
+		ts, labels, end, err := a.parseEventHeader(p[n:])
 		if err != nil {
 			return n, errors.Wrap(err, "parse event")
 		}
 
-		f, s, err := a.file(ts, labels, len(p[n:]))
-		if err != nil {
-			return n, errors.Wrap(err, "get file")
-		}
+		// one event per file write, so the tail can be recovered by event boundaries
+		ev := p[n : n+end]
 
-		m, err := a.writeFile(s, f, p[n:], ts)
-		n += m
+		s := a.stream(labels)
+
+		m, err := a.writeStream(s, ev, ts)
 		if errors.Is(err, ErrFileFull) {
+			n += m
 			continue
 		}
 		if err != nil {
-			return n, errors.Wrap(err, "write")
+			return n + m, errors.Wrap(err, "write")
 		}
+
+		n += m
 	}
 
 	return
 }
 
-func (a *Agent) parseEventHeader(p []byte) (ts int64, labels []byte, err error) {
-	tag, els, i := a.d.Tag(p, 0)
+func (a *Agent) writeStream(s *stream, p []byte, ts int64) (n int, err error) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+
+	n, err = a.writeFile(s, s.file, p, ts)
+	if err != nil {
+		s.stats.Errors++
+		return n, err
+	}
+
+	a.notify(p)
+
+	return n, nil
+}
+
+func (a *Agent) streamsList() []*stream {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	return slices.Clone(a.streams)
+}
+
+// Run periodically syncs files, applies retention policy, and writes metrics until ctx is canceled.
+func (a *Agent) Run(ctx context.Context) error {
+	var cleanup, sync, metrics <-chan time.Time
+
+	if a.CleanupInterval != 0 {
+		t := time.NewTicker(a.CleanupInterval)
+		defer t.Stop()
+
+		cleanup = t.C
+	}
+
+	if a.Sync == SyncInterval && a.SyncPeriod != 0 {
+		t := time.NewTicker(a.SyncPeriod)
+		defer t.Stop()
+
+		sync = t.C
+	}
+
+	if a.Logger != nil && a.MetricsInterval != 0 {
+		t := time.NewTicker(a.MetricsInterval)
+		defer t.Stop()
+
+		metrics = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if a.Sync != SyncNever {
+				err := a.syncFiles()
+				if err != nil {
+					return errors.Wrap(err, "sync")
+				}
+			}
+
+			return ctx.Err()
+		case <-sync:
+			err := a.syncFiles()
+			if err != nil {
+				a.Logger.Printw("db sync", "err", err)
+			}
+		case <-cleanup:
+			err := a.Cleanup(ctx, time.Now().UnixNano())
+			if err != nil {
+				a.Logger.Printw("db cleanup", "err", err)
+			}
+		case <-metrics:
+			a.WriteMetrics(a.Logger)
+		}
+	}
+}
+
+func (a *Agent) syncFiles() (err error) {
+	var files []*file
+
+	for _, s := range a.streamsList() {
+		s.mu.Lock()
+
+		if s.file != nil {
+			files = append(files, s.file)
+		}
+
+		s.mu.Unlock()
+	}
+
+	for _, f := range files {
+		f.mu.Lock()
+
+		if f.dirty {
+			e := f.sync()
+			if err == nil && e != nil {
+				err = errors.Wrap(e, "%v", filepath.Base(f.name))
+			}
+		}
+
+		f.mu.Unlock()
+	}
+
+	return err
+}
+
+// parseEventHeader returns event timestamp, labels, and the event end.
+func (a *Agent) parseEventHeader(p []byte) (ts int64, labels []byte, i int, err error) {
+	var d tlwire.Decoder
+
+	tag, els, i := d.Tag(p, 0)
 	if tag != tlwire.Map {
 		err = errors.New("expected map")
 		return
@@ -139,27 +319,27 @@ func (a *Agent) parseEventHeader(p []byte) (ts int64, labels []byte, err error)
 	var end int
 
 	for el := 0; els == -1 || el < int(els); el++ {
-		if els == -1 && a.d.Break(p, &i) {
+		if els == -1 && d.Break(p, &i) {
 			break
 		}
 
 		st := i
 
-		k, i = a.d.Bytes(p, i)
+		k, i = d.Bytes(p, i)
 		if len(k) == 0 {
 			err = errors.New("empty key")
 			return
 		}
 
-		tag, sub, end = a.d.SkipTag(p, i)
+		tag, sub, end = d.SkipTag(p, i)
 		if tag != tlwire.Semantic {
-			i = a.d.Skip(p, i)
+			i = d.Skip(p, i)
 			continue
 		}
 
 		switch {
 		case sub == tlwire.Time && string(k) == a.KeyTimestamp:
-			ts, i = a.d.Timestamp(p, i)
+			ts, i = d.Timestamp(p, i)
 		case sub == tlog.WireLabel:
 			// labels = crc32.Update(labels, crc32.IEEETable, p[st:end])
 			labels = append(labels, p[st:end]...)
@@ -171,36 +351,36 @@ func (a *Agent) parseEventHeader(p []byte) (ts int64, labels []byte, err error)
 	return
 }
 
-func (a *Agent) file(ts int64, labels []byte, size int) (*file, *stream, error) {
+func (a *Agent) stream(labels []byte) *stream {
 	sum := crc32.ChecksumIEEE(labels)
 
-	var s *stream
+	defer a.mu.Unlock()
+	a.mu.Lock()
 
-	for _, ss := range a.streams {
-		if ss.sum == sum && bytes.Equal(ss.labels, labels) {
-			s = ss
-			break
+	for _, s := range a.streams {
+		if s.sum == sum && bytes.Equal(s.labels, labels) {
+			return s
 		}
 	}
 
-	if s == nil {
-		s = &stream{
-			labels: labels,
-			sum:    sum,
-		}
+	s := &stream{
+		labels: labels,
+		sum:    sum,
+	}
 
-		s.z = eazy.NewWriter(&s.zbuf, eazy.MiB, 1024)
-		s.z.AppendMagic = true
+	s.stats.Labels = labelsString(labels)
 
-		a.streams = append(a.streams, s)
-	}
+	s.z = eazy.NewWriter(&s.zbuf, eazy.MiB, 1024)
+	s.z.AppendMagic = true
 
-	return s.file, s, nil
+	a.streams = append(a.streams, s)
+
+	return s
 }
 
+// writeFile writes event to the stream file.
+// s.mu must be held.
 func (a *Agent) writeFile(s *stream, f *file, p []byte, ts int64) (n int, err error) {
-	tlog.Printw("write message", "i", geti(p))
-
 	s.zbuf = s.zbuf[:0]
 	_, err = s.z.Write(p)
 	if err != nil {
@@ -215,38 +395,61 @@ func (a *Agent) writeFile(s *stream, f *file, p []byte, ts int64) (n int, err er
 			return 0, errors.Wrap(err, "new file")
 		}
 
+		prev := s.file
+
 		s.file = f
+		s.stats.Files++
 
 		s.z.Reset(&s.zbuf)
 		s.boff = 0
+		s.newBlock = f.off != 0
+
+		if prev != nil {
+			err = a.closeFile(prev)
+			if err != nil {
+				return 0, errors.Wrap(err, "close previous file")
+			}
+		}
 	}
 
+	return a.writeBlock(s, f, p, ts)
+}
+
+// writeBlock writes event compressed into s.zbuf to the file f,
+// starting a new block if needed.
+// s.mu must be held.
+func (a *Agent) writeBlock(s *stream, f *file, p []byte, ts int64) (n int, err error) {
 	defer f.mu.Unlock()
 	f.mu.Lock()
 
 	//	tlog.Printw("write file", "file", f.name, "off", tlog.NextAsHex, f.off, "boff", tlog.NextAsHex, s.boff, "block", tlog.NextAsHex, a.BlockSize)
-	nextBlock := false
+	nextBlock := s.newBlock || s.boff+int64(len(s.zbuf)) > a.BlockSize && s.boff != 0
 
-	if nextBlock := s.boff+int64(len(s.zbuf)) > a.BlockSize && s.boff != 0; nextBlock {
+	if nextBlock {
 		err = a.padFile(s, f)
-		tlog.Printw("pad file", "off", tlog.NextAsHex, f.off, "err", err)
 		if err != nil {
 			return 0, errors.Wrap(err, "pad file")
 		}
 
+		s.stats.Blocks++
+
 		s.z.Reset(&s.zbuf)
 		s.boff = 0
+		s.newBlock = false
 	}
 
 	if len(f.index) == 0 || nextBlock {
-		tlog.Printw("append index", "off", tlog.NextAsHex, f.off, "ts", ts/1e9)
-		f.index = append(f.index, ientry{
+		err = f.writeIndex(ientry{
 			off: f.off,
 			ts:  ts,
 		})
+		if err != nil {
+			return 0, errors.Wrap(err, "write index")
+		}
 	}
 
 	if s.boff == 0 {
+		s.z.Reset(&s.zbuf)
 		s.zbuf = s.zbuf[:0]
 		_, err = s.z.Write(p)
 		if err != nil {
@@ -263,49 +466,85 @@ func (a *Agent) writeFile(s *stream, f *file, p []byte, ts int64) (n int, err er
 	f.off += int64(n)
 	s.boff += int64(n)
 
+	s.stats.Events++
+	s.stats.Bytes += int64(len(p))
+	s.stats.CompressedBytes += int64(n)
+
+	if a.Sync == SyncAlways {
+		err = f.sync()
+		if err != nil {
+			return len(p), errors.Wrap(err, "sync")
+		}
+	} else {
+		f.dirty = true
+	}
+
 	return len(p), nil
 }
 
 func (a *Agent) newFile(s *stream, part, ts int64) (*file, error) {
 	//	base := fmt.Sprintf("%08x/%08x_%08x.tlz", part/1e9, s.sum, ts/1e9)
 	base := fmt.Sprintf("%v/%08x_%08x.tlz",
-		time.Unix(0, part).UTC().Format("2006-01-02T15:04"),
+		time.Unix(0, part).UTC().Format(partitionFormat),
 		s.sum,
 		ts/1e9,
 	)
 	fname := filepath.Join(a.path, base)
 	dir := filepath.Dir(fname)
 
-	tlog.Printw("new file", "file", base, "from", loc.Callers(1, 2))
-
 	err := os.MkdirAll(dir, 0o755)
 	if err != nil {
 		return nil, errors.Wrap(err, "mkdir")
 	}
 
-	w, err := os.OpenFile(fname, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-	if err != nil {
-		return nil, errors.Wrap(err, "open file")
-	}
-
 	f := &file{
-		w:    w,
 		name: fname,
 
-		prev: s.file,
-
 		part: part,
 		ts:   ts,
+	}
 
-		//	index: []ientry{{
-		//		off: 0,
-		//		ts:  ts,
-		//	}},
+	err = a.openFile(f)
+	if err != nil {
+		return nil, err
 	}
 
 	return f, nil
 }
 
+// closeFile syncs and closes the file the stream has rotated from.
+func (a *Agent) closeFile(f *file) (err error) {
+	defer f.mu.Unlock()
+	f.mu.Lock()
+
+	if f.dirty && a.Sync != SyncNever {
+		err = f.sync()
+	}
+
+	f.dirty = false
+
+	if e := f.Close(); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+func (f *file) sync() error {
+	for _, w := range []io.Writer{f.idx, f.w} {
+		if s, ok := w.(interface{ Sync() error }); ok {
+			err := s.Sync()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	f.dirty = false
+
+	return nil
+}
+
 func (a *Agent) padFile(s *stream, f *file) error {
 	if f.off%a.BlockSize == 0 {
 		s.boff = 0
@@ -341,36 +580,3 @@ func (a *Agent) padFile(s *stream, f *file) error {
 
 	return nil
 }
-
-func geti(p []byte) (x int64) {
-	var d tlwire.LowDecoder
-
-	tag, els, i := d.Tag(p, 0)
-	if tag != tlwire.Map {
-		return -1
-	}
-
-	var k []byte
-	var sub int64
-	var end int
-
-	for el := 0; els == -1 || el < int(els); el++ {
-		if els == -1 && d.Break(p, &i) {
-			break
-		}
-
-		k, i = d.Bytes(p, i)
-		if len(k) == 0 {
-			return -1
-		}
-
-		tag, sub, end = d.SkipTag(p, i)
-		if tag == tlwire.Int && string(k) == "i" {
-			return sub
-		}
-
-		i = end
-	}
-
-	return -1
-}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestConcurrentWrite(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	const streams, events = 8, 200
+
+	var wg sync.WaitGroup
+
+	for j := range streams {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			l := tlog.New(a)
+			l.SetLabels("stream", j)
+
+			for i := range events {
+				l.Printw("message", "i", i)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for _, st := range a.Stats() {
+		assert.Equal(t, int64(events), st.Events, "stream %v", st.Labels)
+	}
+
+	for j := range streams {
+		var out low.Buf
+
+		err = a.Query(context.Background(), &out, 0, fmt.Sprintf("{stream=%d}", j))
+		assert.NoError(t, err)
+
+		var d tlwire.Decoder
+		var exp int64
+
+		for i := 0; i < len(out); i = d.Skip(out, i) {
+			assert.Equal(t, exp, geti(out[i:]), "stream %d", j)
+			exp++
+		}
+
+		assert.Equal(t, int64(events), exp, "stream %d", j)
+	}
+}
+
+func TestRotatedFileClosed(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	a.FileSize = 1 // file per event
+
+	l := tlog.New(a)
+
+	l.Printw("first")
+
+	s := a.streamsList()[0]
+	prev := s.file
+
+	l.Printw("second")
+
+	assert.True(t, s.file != prev, "file rotated")
+
+	for _, w := range []interface{}{prev.w, prev.idx} {
+		_, err = w.(*os.File).Write([]byte{0})
+		assert.ErrorIs(t, err, os.ErrClosed)
+	}
+}
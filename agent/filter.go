@@ -0,0 +1,513 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// query is a parsed query string.
+	//
+	// Query is a whitespace separated list of terms. Event must match all of them.
+	//
+	//	{service=api,env!=dev}  label selectors, matched against WireLabel fields only
+	//	key=value  key!=value   equality, value may be double quoted
+	//	key>1  key<=2.5         comparison: numbers, durations (_e>100ms), timestamps
+	//	key~regexp  key!~regexp regexp match
+	//	word  "some words"      message substring
+	//
+	// Timestamps are RFC3339 dates or durations relative to the query time (_t>=-1h).
+	// Comparisons on the timestamp key narrow the time range [from, to) to scan.
+	query struct {
+		labels []pred
+		preds  []pred
+		text   [][]byte
+
+		tskey string
+
+		from, to int64
+
+		d tlwire.Decoder
+
+		hits []bool
+	}
+
+	pred struct {
+		key string
+		op  op
+		not bool
+		val string
+
+		num   float64
+		isnum bool
+		dur   int64
+		isdur bool
+		ts    int64
+		ists  bool
+
+		re *regexp.Regexp
+	}
+
+	op int
+
+	qvalue struct {
+		kind qkind
+		s    []byte
+		num  float64
+		i    int64
+	}
+
+	qkind int
+)
+
+const (
+	opEq op = iota
+	opMatch
+	opLess
+	opLessEq
+	opGreater
+	opGreaterEq
+)
+
+const (
+	kindOther qkind = iota
+	kindString
+	kindNum
+	kindTime
+	kindDuration
+	kindID
+)
+
+var levels = map[string]tlog.LogLevel{
+	"debug": tlog.Debug,
+	"info":  tlog.Info,
+	"warn":  tlog.Warn,
+	"error": tlog.Error,
+	"fatal": tlog.Fatal,
+}
+
+func parseQuery(q, tskey string, now int64) (*query, error) {
+	x := &query{
+		tskey: tskey,
+	}
+
+	i := 0
+
+	for {
+		i = skipSpaces(q, i)
+		if i == len(q) {
+			break
+		}
+
+		switch q[i] {
+		case '{':
+			i++
+
+			for {
+				i = skipSpaces(q, i)
+
+				if i < len(q) && q[i] == '}' {
+					i++
+					break
+				}
+
+				p, end, err := parsePred(q, i, now, ",}")
+				if err != nil {
+					return nil, errors.Wrap(err, "label at pos %d", i)
+				}
+
+				x.labels = append(x.labels, p)
+
+				i = skipSpaces(q, end)
+				if i < len(q) && q[i] == ',' {
+					i++
+				}
+			}
+		case '"':
+			s, end, err := parseValue(q, i, "")
+			if err != nil {
+				return nil, errors.Wrap(err, "text at pos %d", i)
+			}
+
+			x.text = append(x.text, []byte(s))
+			i = end
+		default:
+			end := i
+			for end < len(q) && !isSpace(q[end]) && strings.IndexByte("=!~<>", q[end]) == -1 {
+				end++
+			}
+
+			if end == len(q) || isSpace(q[end]) {
+				x.text = append(x.text, []byte(q[i:end]))
+				i = end
+
+				break
+			}
+
+			p, end, err := parsePred(q, i, now, "")
+			if err != nil {
+				return nil, errors.Wrap(err, "term at pos %d", i)
+			}
+
+			x.preds = append(x.preds, p)
+			i = end
+		}
+	}
+
+	for _, p := range x.preds {
+		if p.key != tskey || !p.ists || p.not {
+			continue
+		}
+
+		switch p.op {
+		case opGreater:
+			x.from = max(x.from, p.ts+1)
+		case opGreaterEq:
+			x.from = max(x.from, p.ts)
+		case opLess:
+			x.to = minNonZero(x.to, p.ts)
+		case opLessEq:
+			x.to = minNonZero(x.to, p.ts+1)
+		}
+	}
+
+	x.hits = make([]bool, len(x.labels)+len(x.preds)+len(x.text))
+
+	return x, nil
+}
+
+func parsePred(q string, st int, now int64, stop string) (p pred, i int, err error) {
+	i = st
+
+	for i < len(q) && !isSpace(q[i]) && strings.IndexByte("=!~<>", q[i]) == -1 {
+		i++
+	}
+
+	p.key = q[st:i]
+	if p.key == "" {
+		return p, i, errors.New("empty key")
+	}
+
+	switch {
+	case strings.HasPrefix(q[i:], "!="):
+		p.op, p.not = opEq, true
+	case strings.HasPrefix(q[i:], "!~"):
+		p.op, p.not = opMatch, true
+	case strings.HasPrefix(q[i:], ">="):
+		p.op = opGreaterEq
+	case strings.HasPrefix(q[i:], "<="):
+		p.op = opLessEq
+	case strings.HasPrefix(q[i:], "="):
+		p.op = opEq
+	case strings.HasPrefix(q[i:], "~"):
+		p.op = opMatch
+	case strings.HasPrefix(q[i:], ">"):
+		p.op = opGreater
+	case strings.HasPrefix(q[i:], "<"):
+		p.op = opLess
+	default:
+		return p, i, errors.New("operator expected")
+	}
+
+	if p.not || p.op == opGreaterEq || p.op == opLessEq {
+		i += 2
+	} else {
+		i++
+	}
+
+	p.val, i, err = parseValue(q, i, stop)
+	if err != nil {
+		return p, i, err
+	}
+
+	if p.op == opMatch {
+		p.re, err = regexp.Compile(p.val)
+		if err != nil {
+			return p, i, errors.Wrap(err, "regexp")
+		}
+
+		return p, i, nil
+	}
+
+	if lvl, ok := levels[strings.ToLower(p.val)]; ok && p.key == tlog.KeyLogLevel {
+		p.num, p.isnum = float64(lvl), true
+	} else if x, err := strconv.ParseFloat(p.val, 64); err == nil {
+		p.num, p.isnum = x, true
+	}
+
+	if d, err := time.ParseDuration(p.val); err == nil {
+		p.dur, p.isdur = int64(d), true
+	}
+
+	p.ts, p.ists = parseTime(p.val, now)
+
+	return p, i, nil
+}
+
+func parseValue(q string, st int, stop string) (v string, i int, err error) {
+	i = st
+
+	if i < len(q) && q[i] == '"' {
+		i++
+
+		for i < len(q) && q[i] != '"' {
+			if q[i] == '\\' {
+				i++
+			}
+
+			i++
+		}
+
+		if i >= len(q) {
+			return "", i, errors.New("unterminated string")
+		}
+
+		i++
+
+		v, err = strconv.Unquote(q[st:i])
+
+		return v, i, err
+	}
+
+	for i < len(q) && !isSpace(q[i]) && strings.IndexByte(stop, q[i]) == -1 {
+		i++
+	}
+
+	return q[st:i], i, nil
+}
+
+func parseTime(s string, now int64) (int64, bool) {
+	if s == "now" {
+		return now, true
+	}
+
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		d, err := time.ParseDuration(s)
+		if err == nil {
+			return now + int64(d), true
+		}
+	}
+
+	for _, f := range []string{time.RFC3339Nano, "2006-01-02T15:04", "2006-01-02"} {
+		t, err := time.Parse(f, s)
+		if err == nil {
+			return t.UnixNano(), true
+		}
+	}
+
+	return 0, false
+}
+
+// match reports whether event p matches the query.
+// It also returns event timestamp.
+// It's not safe for concurrent use.
+func (q *query) match(p []byte) (ts int64, ok bool) {
+	tag, els, i := q.d.Tag(p, 0)
+	if tag != tlwire.Map {
+		return 0, false
+	}
+
+	for j := range q.hits {
+		q.hits[j] = false
+	}
+
+	var k []byte
+	var v qvalue
+
+	for el := 0; els == -1 || el < int(els); el++ {
+		if els == -1 && q.d.Break(p, &i) {
+			break
+		}
+
+		k, i = q.d.Bytes(p, i)
+
+		label := p[i] == byte(tlwire.Semantic|tlog.WireLabel)
+		msg := p[i] == byte(tlwire.Semantic|tlog.WireMessage)
+
+		v, i = q.value(p, i)
+
+		if v.kind == kindTime && string(k) == q.tskey {
+			ts = v.i
+		}
+
+		for j, pr := range q.labels {
+			if label && !q.hits[j] && pr.key == string(k) {
+				q.hits[j] = pr.match(v)
+			}
+		}
+
+		for j, pr := range q.preds {
+			j += len(q.labels)
+
+			if !q.hits[j] && pr.key == string(k) {
+				q.hits[j] = pr.match(v)
+			}
+		}
+
+		if (msg || string(k) == tlog.KeyMessage) && v.kind == kindString {
+			for j, t := range q.text {
+				j += len(q.labels) + len(q.preds)
+
+				q.hits[j] = q.hits[j] || bytes.Contains(v.s, t)
+			}
+		}
+	}
+
+	if q.from != 0 && ts < q.from || q.to != 0 && ts >= q.to {
+		return ts, false
+	}
+
+	for j, pr := range q.labels {
+		if q.hits[j] == pr.not {
+			return ts, false
+		}
+	}
+
+	for j, pr := range q.preds {
+		if q.hits[len(q.labels)+j] == pr.not {
+			return ts, false
+		}
+	}
+
+	for j := range q.text {
+		if !q.hits[len(q.labels)+len(q.preds)+j] {
+			return ts, false
+		}
+	}
+
+	return ts, true
+}
+
+func (q *query) value(p []byte, st int) (v qvalue, i int) {
+	tag, sub, i := q.d.Tag(p, st)
+
+	switch tag {
+	case tlwire.Int, tlwire.Neg:
+		x, i := q.d.Signed(p, st)
+
+		return qvalue{kind: kindNum, num: float64(x)}, i
+	case tlwire.String, tlwire.Bytes:
+		v.s, i = q.d.Bytes(p, st)
+		v.kind = kindString
+
+		return v, i
+	case tlwire.Semantic:
+		switch sub {
+		case tlwire.Time:
+			v.i, i = q.d.Timestamp(p, st)
+			v.kind = kindTime
+
+			return v, i
+		case tlwire.Duration:
+			d, i := q.d.Duration(p, st)
+
+			return qvalue{kind: kindDuration, i: int64(d)}, i
+		case tlog.WireID:
+			var id tlog.ID
+			i = id.TlogParse(p, st)
+
+			return qvalue{kind: kindID, s: hex.AppendEncode(nil, id[:])}, i
+		case tlwire.Caller, tlwire.Error:
+			return qvalue{}, q.d.Skip(p, st)
+		}
+
+		return q.value(p, i)
+	case tlwire.Special:
+		switch q.d.Simple(p, st) {
+		case tlwire.True:
+			return qvalue{kind: kindString, s: []byte("true")}, i
+		case tlwire.False:
+			return qvalue{kind: kindString, s: []byte("false")}, i
+		case tlwire.Float64, tlwire.Float32, tlwire.Float16, tlwire.Float8:
+			f, i := q.d.Float(p, st)
+
+			return qvalue{kind: kindNum, num: f}, i
+		}
+	}
+
+	return qvalue{}, q.d.Skip(p, st)
+}
+
+// match checks the value against the positive form of the predicate.
+// Negation is applied by the caller.
+func (p pred) match(v qvalue) bool {
+	if p.op == opMatch {
+		return (v.kind == kindString || v.kind == kindID) && p.re.Match(v.s)
+	}
+
+	var c int
+
+	switch {
+	case v.kind == kindString:
+		c = strings.Compare(string(v.s), p.val)
+	case v.kind == kindID:
+		if p.op == opEq {
+			return strings.HasPrefix(string(v.s), strings.ToLower(p.val))
+		}
+
+		c = strings.Compare(string(v.s), p.val)
+	case v.kind == kindNum && p.isnum:
+		c = compare(v.num, p.num)
+	case v.kind == kindDuration && p.isdur:
+		c = compare(v.i, p.dur)
+	case v.kind == kindTime && p.ists:
+		c = compare(v.i, p.ts)
+	default:
+		return false
+	}
+
+	switch p.op {
+	case opEq:
+		return c == 0
+	case opLess:
+		return c < 0
+	case opLessEq:
+		return c <= 0
+	case opGreater:
+		return c > 0
+	case opGreaterEq:
+		return c >= 0
+	}
+
+	return false
+}
+
+func compare[T int64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func minNonZero(x, y int64) int64 {
+	if x == 0 || y < x {
+		return y
+	}
+
+	return x
+}
+
+func skipSpaces(q string, i int) int {
+	for i < len(q) && isSpace(q[i]) {
+		i++
+	}
+
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestQueryMatch(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf low.Buf
+
+	l := tlog.New(&buf)
+	tlog.LoggerSetTimeNow(l, func() time.Time { return now }, func() int64 { return now.UnixNano() })
+	l.SetLabels("service", "api", "env", "prod")
+
+	l.Printw("connection refused", "addr", "10.0.0.1:80", "attempt", 3, "elapsed", 150*time.Millisecond, "", tlog.Warn)
+
+	ev := append([]byte{}, buf...)
+
+	for _, tc := range []struct {
+		q  string
+		ok bool
+	}{
+		{"", true},
+		{`{service=api}`, true},
+		{`{service=api, env!=prod}`, false},
+		{`{addr="10.0.0.1:80"}`, false}, // not a label
+		{`addr="10.0.0.1:80"`, true},
+		{`service=api`, true},
+		{`attempt>2 attempt<=3`, true},
+		{`attempt>3`, false},
+		{`elapsed>=100ms`, true},
+		{`elapsed>1s`, false},
+		{`_l=warn`, true},
+		{`_l>=error`, false},
+		{`addr~^10\.`, true},
+		{`addr!~^10\.`, false},
+		{`refused`, true},
+		{`"connection refused"`, true},
+		{`"connection accepted"`, false},
+		{`_t>=-1h`, true},
+		{`_t<2025-03-01`, false},
+		{`_t>=2025-03-01T11:00 _t<2025-03-01T13:00`, true},
+	} {
+		q, err := parseQuery(tc.q, tlog.KeyTimestamp, now.Add(time.Minute).UnixNano())
+		if !assert.NoError(t, err, "query: %q", tc.q) {
+			continue
+		}
+
+		ts, ok := q.match(ev)
+		assert.Equal(t, tc.ok, ok, "query: %q\n%s", tc.q, tlwire.Dump(ev))
+		assert.Equal(t, now.UnixNano(), ts)
+	}
+}
+
+func TestQueryParseErrors(t *testing.T) {
+	for _, q := range []string{
+		`{service}`,
+		`key="unterminated`,
+		`=value`,
+		`key~(`,
+	} {
+		_, err := parseQuery(q, tlog.KeyTimestamp, 0)
+		assert.Error(t, err, "query: %q", q)
+	}
+}
+
+func TestQueryFiles(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	base := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	now := base
+
+	newLogger := func(service string) *tlog.Logger {
+		l := tlog.New(a)
+		tlog.LoggerSetTimeNow(l, func() time.Time { return now }, func() int64 { return now.UnixNano() })
+		tlog.LoggerSetCallers(l, 0, func(int, []uintptr) int { return 0 })
+		l.SetLabels("service", service)
+
+		return l
+	}
+
+	l1 := newLogger("one")
+	l2 := newLogger("two")
+
+	for i := range 6 {
+		now = base.Add(time.Duration(i) * time.Hour)
+
+		if i%2 == 0 {
+			l1.Printw("message", "i", i)
+		} else {
+			l2.Printw("message", "i", i)
+		}
+	}
+
+	var out low.Buf
+
+	err = a.Query(context.Background(), &out, now.Add(time.Hour).UnixNano(), `i>=1 i<5`)
+	assert.NoError(t, err)
+
+	var is []int64
+	var d tlwire.Decoder
+
+	for i := 0; i < len(out); {
+		end := d.Skip(out, i)
+
+		q, err := parseQuery("", tlog.KeyTimestamp, 0)
+		assert.NoError(t, err)
+
+		ts, ok := q.match(out[i:end])
+		assert.True(t, ok)
+
+		is = append(is, int64(time.Unix(0, ts).Sub(base)/time.Hour))
+
+		i = end
+	}
+
+	assert.Equal(t, []int64{1, 2, 3, 4}, is)
+}
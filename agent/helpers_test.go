@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"tlog.app/go/tlog/tlwire"
+)
+
+// geti returns the "i" int value of the event or -1.
+func geti(p []byte) (x int64) {
+	var d tlwire.LowDecoder
+
+	tag, els, i := d.Tag(p, 0)
+	if tag != tlwire.Map {
+		return -1
+	}
+
+	var k []byte
+	var sub int64
+	var end int
+
+	for el := 0; els == -1 || el < int(els); el++ {
+		if els == -1 && d.Break(p, &i) {
+			break
+		}
+
+		k, i = d.Bytes(p, i)
+		if len(k) == 0 {
+			return -1
+		}
+
+		tag, sub, end = d.SkipTag(p, i)
+		if tag == tlwire.Int && string(k) == "i" {
+			return sub
+		}
+
+		i = end
+	}
+
+	return -1
+}
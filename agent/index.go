@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nikand.dev/go/hacked/hnet"
+	"tlog.app/go/eazy"
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog/tlwire"
+)
+
+// Each data file has a sidecar index file with the same name and indexExt appended.
+// Index is a sequence of [off, ts] tlwire arrays, one for each block of the data file.
+// off is the block offset in the data file and ts is the timestamp of its first event.
+const indexExt = ".idx"
+
+func appendIndex(b []byte, e ientry) []byte {
+	var e0 tlwire.Encoder
+
+	b = e0.AppendArray(b, 2)
+	b = e0.AppendInt64(b, e.off)
+	b = e0.AppendTimestamp(b, e.ts)
+
+	return b
+}
+
+func (f *file) writeIndex(e ientry) error {
+	f.index = append(f.index, e)
+
+	if f.idx == nil {
+		return nil
+	}
+
+	_, err := f.idx.Write(appendIndex(nil, e))
+
+	return err
+}
+
+// readIndex reads the sidecar index of the data file.
+// Missing index is not an error, the file is scanned entirely in that case.
+// Truncated last entry is ignored.
+func readIndex(name string) (idx []ientry, err error) {
+	f, err := os.Open(name + indexExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+
+	defer hnet.Closer(f, &err, "close")
+
+	var d tlwire.Decoder
+	r := tlwire.NewReader(f)
+
+	for {
+		p, err := r.ReadOne()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return idx, nil
+		}
+		if err != nil {
+			return idx, errors.Wrap(err, "read entry")
+		}
+
+		tag, els, i := d.Tag(p, 0)
+		if tag != tlwire.Array || els != 2 {
+			return idx, errors.New("bad index entry")
+		}
+
+		var e ientry
+
+		e.off, i = d.Signed(p, i)
+		e.ts, _ = d.Timestamp(p, i)
+
+		idx = append(idx, e)
+	}
+}
+
+// blockRange returns the data file range which may contain events in [from, to).
+// Events in a stream are expected to be ordered by time.
+// end == -1 means the end of the file.
+func blockRange(idx []ientry, from, to int64) (st, end int64) {
+	end = -1
+
+	for _, e := range idx {
+		if from != 0 && e.ts < from {
+			st = e.off
+		}
+
+		if to != 0 && e.ts >= to {
+			end = e.off
+			break
+		}
+	}
+
+	return st, end
+}
+
+// load restores streams from the db dir tree.
+// Each stream continues writing to its latest file starting from a new block.
+func (a *Agent) load() error {
+	parts, err := a.partitions()
+	if err != nil {
+		return errors.Wrap(err, "list partitions")
+	}
+
+	latest := map[uint32]*file{}
+
+	for _, part := range parts {
+		files, err := os.ReadDir(part.dir)
+		if err != nil {
+			return errors.Wrap(err, "read dir")
+		}
+
+		for _, fi := range files {
+			if !fi.IsDir() && (strings.HasSuffix(fi.Name(), ".tmp") || strings.HasSuffix(fi.Name(), ".tmp"+indexExt)) {
+				// unfinished compaction
+				err = os.Remove(filepath.Join(part.dir, fi.Name()))
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return errors.Wrap(err, "remove tmp file")
+				}
+
+				continue
+			}
+
+			if fi.IsDir() || filepath.Ext(fi.Name()) != ".tlz" {
+				continue
+			}
+
+			var sum uint32
+			var ts int64
+
+			_, err = fmt.Sscanf(strings.TrimSuffix(fi.Name(), ".tlz"), "%08x_%08x", &sum, &ts)
+			if err != nil {
+				continue
+			}
+
+			f := &file{
+				name: filepath.Join(part.dir, fi.Name()),
+				part: part.start,
+				ts:   ts * 1e9,
+			}
+
+			if l := latest[sum]; l == nil || l.part < f.part || l.part == f.part && l.ts <= f.ts {
+				latest[sum] = f
+			}
+		}
+	}
+
+	for sum, f := range latest {
+		s, err := a.loadStream(sum, f)
+		if err != nil {
+			return errors.Wrap(err, "file %v", f.name)
+		}
+
+		if s != nil {
+			a.streams = append(a.streams, s)
+		}
+	}
+
+	return nil
+}
+
+func (a *Agent) loadStream(sum uint32, f *file) (s *stream, err error) {
+	f.index, err = readIndex(f.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "read index")
+	}
+
+	err = a.recoverTail(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "recover tail")
+	}
+
+	labels, err := a.readLabels(f.name)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// no complete events, the file will be created again
+		return nil, removeFile(f.name)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read labels")
+	}
+
+	err = a.openFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	s = &stream{
+		labels: labels,
+		sum:    sum,
+		file:   f,
+	}
+
+	s.stats.Labels = labelsString(labels)
+
+	s.z = eazy.NewWriter(&s.zbuf, eazy.MiB, 1024)
+	s.z.AppendMagic = true
+
+	// compression state is lost, so start a new block
+	s.newBlock = f.off != 0
+
+	return s, nil
+}
+
+func (a *Agent) readLabels(name string) (labels []byte, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+
+	defer hnet.Closer(f, &err, "close")
+
+	p, err := tlwire.NewReader(eazy.NewReader(f)).ReadOne()
+	if err != nil {
+		return nil, err
+	}
+
+	_, labels, _, err = a.parseEventHeader(p)
+
+	return labels, err
+}
+
+func (a *Agent) openFile(f *file) error {
+	w, err := os.OpenFile(f.name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+
+	off, err := w.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = w.Close()
+		return errors.Wrap(err, "seek")
+	}
+
+	idx, err := os.OpenFile(f.name+indexExt, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		_ = w.Close()
+		return errors.Wrap(err, "open index")
+	}
+
+	f.w = w
+	f.idx = idx
+	f.off = off
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestIndexReload(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	write := func(a *Agent, from, to int) {
+		l := tlog.New(a)
+		l.SetLabels("service", "api")
+
+		for i := from; i < to; i++ {
+			now := base.Add(time.Duration(i) * time.Second).UnixNano()
+			tlog.LoggerSetTimeNow(l, func() time.Time { return time.Unix(0, now) }, func() int64 { return now })
+
+			l.Printw("message", "i", i)
+		}
+	}
+
+	a, err := New(dir)
+	assert.NoError(t, err)
+
+	a.BlockSize = 256
+
+	write(a, 0, 20)
+
+	assert.Equal(t, 1, len(a.streams))
+	f := a.streams[0].file
+	assert.True(t, len(f.index) > 1, "index: %v", f.index)
+
+	idx, err := readIndex(f.name)
+	assert.NoError(t, err)
+	assert.Equal(t, f.index, idx)
+
+	a, err = New(dir)
+	assert.NoError(t, err)
+
+	a.BlockSize = 256
+
+	if assert.Equal(t, 1, len(a.streams)) {
+		assert.Equal(t, f.name, a.streams[0].file.name)
+		assert.Equal(t, f.off, a.streams[0].file.off)
+	}
+
+	write(a, 20, 30)
+
+	assert.Equal(t, 1, len(a.streams))
+
+	is := func(q string) (r []int64) {
+		var out low.Buf
+
+		err := a.Query(context.Background(), &out, 0, q)
+		assert.NoError(t, err)
+
+		var d tlwire.Decoder
+
+		for i := 0; i < len(out); i = d.Skip(out, i) {
+			r = append(r, geti(out[i:]))
+		}
+
+		return r
+	}
+
+	assert.Equal(t, 30, len(is("")))
+	assert.Equal(t, []int64{18, 19, 20, 21}, is(`_t>=2025-03-01T12:00:18Z _t<2025-03-01T12:00:22Z`))
+}
+
+func TestBlockRange(t *testing.T) {
+	idx := []ientry{{0, 10}, {100, 20}, {200, 30}}
+
+	for _, tc := range []struct {
+		from, to int64
+		st, end  int64
+	}{
+		{0, 0, 0, -1},
+		{15, 0, 0, -1},
+		{20, 0, 0, -1},
+		{21, 0, 100, -1},
+		{25, 0, 100, -1},
+		{35, 0, 200, -1},
+		{0, 20, 0, 100},
+		{0, 21, 0, 200},
+		{15, 25, 0, 200},
+		{0, 5, 0, 0},
+	} {
+		st, end := blockRange(idx, tc.from, tc.to)
+		assert.Equal(t, tc.st, st, "from %d to %d", tc.from, tc.to)
+		assert.Equal(t, tc.end, end, "from %d to %d", tc.from, tc.to)
+	}
+}
@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"tlog.app/go/eazy"
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// merger reads matching events of a partition files in timestamp order.
+	// Events with equal timestamps are ordered by file name and then by position in the file.
+	merger struct {
+		q *query
+
+		iters mergeHeap
+		files []*fileIter
+
+		buf []byte
+	}
+
+	mergeHeap []*fileIter
+
+	fileIter struct {
+		f *os.File
+		r *tlwire.Reader
+		n int // file order for tie-break
+
+		ts int64
+		p  []byte
+	}
+)
+
+func (a *Agent) newMerger(ctx context.Context, part partition, q *query) (m *merger, err error) {
+	ents, err := os.ReadDir(part.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read dir")
+	}
+
+	names := make([]string, 0, len(ents))
+
+	for _, e := range ents {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".tlz" {
+			continue
+		}
+
+		names = append(names, e.Name())
+	}
+
+	sort.Strings(names)
+
+	m = &merger{q: q}
+
+	defer func() {
+		if err != nil {
+			_ = m.Close()
+		}
+	}()
+
+	for _, name := range names {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		it, err := m.openFile(filepath.Join(part.dir, name), len(m.files))
+		if err != nil {
+			return nil, errors.Wrap(err, "file %v", name)
+		}
+		if it == nil {
+			continue
+		}
+
+		m.files = append(m.files, it)
+
+		ok, err := m.read(it)
+		if err != nil {
+			return nil, errors.Wrap(err, "file %v", name)
+		}
+
+		if ok {
+			m.iters = append(m.iters, it)
+		}
+	}
+
+	heap.Init(&m.iters)
+
+	return m, nil
+}
+
+// openFile opens the file blocks which may contain events in [q.from, q.to).
+// It returns nil if there are no such blocks.
+func (m *merger) openFile(name string, n int) (_ *fileIter, err error) {
+	idx, err := readIndex(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "read index")
+	}
+
+	st, end := blockRange(idx, m.q.from, m.q.to)
+	if end < 0 {
+		end = math.MaxInt64
+	}
+
+	if st == end {
+		return nil, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+
+	return &fileIter{
+		f: f,
+		r: tlwire.NewReader(eazy.NewReader(io.NewSectionReader(f, st, end-st))),
+		n: n,
+	}, nil
+}
+
+// Next returns the next event. The event is valid until the next call.
+// It returns io.EOF when there are no more events.
+func (m *merger) Next() (ts int64, p []byte, err error) {
+	if len(m.iters) == 0 {
+		return 0, nil, io.EOF
+	}
+
+	it := m.iters[0]
+
+	// it.p is overwritten by the read below
+	m.buf = append(m.buf[:0], it.p...)
+	ts, p = it.ts, m.buf
+
+	ok, err := m.read(it)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "file %v", filepath.Base(it.f.Name()))
+	}
+
+	if ok {
+		heap.Fix(&m.iters, 0)
+	} else {
+		heap.Pop(&m.iters)
+	}
+
+	return ts, p, nil
+}
+
+// read reads the next matching event of the file.
+func (m *merger) read(it *fileIter) (bool, error) {
+	for {
+		p, err := it.r.ReadOne()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// the last event may be still being written
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "read event")
+		}
+
+		ts, ok := m.q.match(p)
+		if !ok {
+			continue
+		}
+
+		it.ts = ts
+		it.p = p
+
+		return true, nil
+	}
+}
+
+func (m *merger) Close() (err error) {
+	for _, it := range m.files {
+		e := it.f.Close()
+		if err == nil && e != nil {
+			err = errors.Wrap(e, "close %v", filepath.Base(it.f.Name()))
+		}
+	}
+
+	return err
+}
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].ts != h[j].ts {
+		return h[i].ts < h[j].ts
+	}
+
+	return h[i].n < h[j].n
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(*fileIter)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return x
+}
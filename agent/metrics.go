@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"strconv"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// StreamStats are ingestion counters of a label set stream.
+	StreamStats struct {
+		Labels string // k=v,k2=v2
+
+		Events          int64
+		Bytes           int64 // raw events size
+		CompressedBytes int64 // written to files including block headers
+		Files           int64 // files opened
+		Blocks          int64 // blocks padded
+		Errors          int64
+	}
+)
+
+// Stats returns per stream ingestion counters.
+func (a *Agent) Stats() []StreamStats {
+	streams := a.streamsList()
+	r := make([]StreamStats, len(streams))
+
+	for i, s := range streams {
+		s.mu.Lock()
+		r[i] = s.stats
+		s.mu.Unlock()
+	}
+
+	return r
+}
+
+// WriteMetrics writes stream counters to l as EventMetric events.
+func (a *Agent) WriteMetrics(l *tlog.Logger) {
+	for _, s := range a.Stats() {
+		for _, m := range []struct {
+			name string
+			val  int64
+		}{
+			{"tlog_agent_events_total", s.Events},
+			{"tlog_agent_bytes_total", s.Bytes},
+			{"tlog_agent_compressed_bytes_total", s.CompressedBytes},
+			{"tlog_agent_files_total", s.Files},
+			{"tlog_agent_blocks_total", s.Blocks},
+			{"tlog_agent_errors_total", s.Errors},
+		} {
+			l.NewMessage(-1, tlog.ID{}, m.name, "", tlog.EventMetric, tlog.KeyMetricValue, m.val, "stream", s.Labels)
+		}
+	}
+}
+
+// labelsString formats raw labels as k=v,k2=v2.
+func labelsString(labels []byte) string {
+	var d tlwire.Decoder
+	var b []byte
+
+	for i := 0; i < len(labels); {
+		var k []byte
+		k, i = d.Bytes(labels, i)
+
+		if len(b) != 0 {
+			b = append(b, ',')
+		}
+
+		b = append(b, k...)
+		b = append(b, '=')
+
+		tag, sub, vst := d.Tag(labels, i)
+		if tag == tlwire.Semantic && sub == tlog.WireLabel {
+			i = vst
+		}
+
+		switch d.TagOnly(labels, i) {
+		case tlwire.String, tlwire.Bytes:
+			var v []byte
+			v, i = d.Bytes(labels, i)
+
+			b = append(b, v...)
+		case tlwire.Int, tlwire.Neg:
+			var v int64
+			v, i = d.Signed(labels, i)
+
+			b = strconv.AppendInt(b, v, 10)
+		default:
+			i = d.Skip(labels, i)
+		}
+	}
+
+	return string(b)
+}
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestStats(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	l := tlog.New(a)
+	l.SetLabels("service", "api", "shard", 3)
+
+	for i := range 3 {
+		l.Printw("message", "i", i)
+	}
+
+	st := a.Stats()
+	if !assert.Equal(t, 1, len(st)) {
+		return
+	}
+
+	assert.Equal(t, "service=api,shard=3", st[0].Labels)
+	assert.Equal(t, int64(3), st[0].Events)
+	assert.Equal(t, int64(1), st[0].Files)
+	assert.True(t, st[0].Bytes > 0 && st[0].CompressedBytes > 0)
+
+	var buf low.Buf
+
+	a.WriteMetrics(tlog.New(&buf))
+
+	q, err := parseQuery(`_k=m stream="service=api,shard=3"`, tlog.KeyTimestamp, 0)
+	assert.NoError(t, err)
+
+	var d tlwire.Decoder
+	n := 0
+
+	for i := 0; i < len(buf); i = d.Skip(buf, i) {
+		_, ok := q.match(buf[i:])
+		assert.True(t, ok, "%s", tlwire.Dump(buf[i:]))
+
+		n++
+	}
+
+	assert.Equal(t, 6, n)
+}
@@ -3,54 +3,235 @@ package agent
 import (
 	"context"
 	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nikand.dev/go/hacked/hnet"
+	"tlog.app/go/errors"
 )
 
 type (
-	sub struct {
-		io.Writer
+	partition struct {
+		dir   string
+		start int64
+	}
 
-		id int64
+	qevent struct {
+		ts int64
+		p  []byte
 	}
 )
 
+const partitionFormat = "2006-01-02T15:04"
+
+// Query writes events matching q to w ordered by timestamp.
+// ts is the query time: relative times in q are resolved against it
+// and events after it are not returned.
 func (a *Agent) Query(ctx context.Context, w io.Writer, ts int64, q string) error {
-	return nil
+	return a.QueryPage(ctx, w, ts, 0, 0, q)
 }
 
-func (a *Agent) Subscribe(ctx context.Context, w io.Writer, q string) (int64, error) {
-	defer a.mu.Unlock()
-	a.mu.Lock()
+// QueryPage writes a page of events matching q to w ordered by timestamp.
+// now is the query time as for Query.
+//
+// If limit > 0 the first limit events with timestamp >= cursor are written.
+// If limit < 0 the last -limit events with timestamp < cursor are written.
+// If limit == 0 all the events are written and cursor is ignored.
+// Events with the same timestamp are never split between pages,
+// so a page may be longer than limit.
+//
+// The cursor for the next page forward is the last event timestamp + 1,
+// and the cursor for the previous page is the first event timestamp.
+func (a *Agent) QueryPage(ctx context.Context, w io.Writer, now, cursor int64, limit int, q string) error {
+	qq, err := parseQuery(q, a.KeyTimestamp, now)
+	if err != nil {
+		return errors.Wrap(err, "parse query")
+	}
 
-	a.subid++
-	id := a.subid
+	if now != 0 {
+		qq.to = minNonZero(qq.to, now+1)
+	}
 
-	sub := sub{
-		Writer: w,
-		id:     id,
+	switch {
+	case limit > 0:
+		qq.from = max(qq.from, cursor)
+	case limit < 0 && cursor != 0:
+		qq.to = minNonZero(qq.to, cursor)
 	}
 
-	a.subs = append(a.subs, sub)
+	parts, err := a.partitions()
+	if err != nil {
+		return errors.Wrap(err, "list partitions")
+	}
 
-	return id, nil
-}
+	i, j := 0, len(parts)
 
-func (a *Agent) Unsubscribe(ctx context.Context, id int64) error {
-	defer a.mu.Unlock()
-	a.mu.Lock()
+	for i < j && qq.from != 0 && parts[i].start+int64(a.Partition) <= qq.from {
+		i++
+	}
 
-	i := 0
+	for i < j && qq.to != 0 && parts[j-1].start >= qq.to {
+		j--
+	}
 
-	for i < len(a.subs) && a.subs[i].id < id {
-		i++
+	parts = parts[i:j]
+
+	if limit < 0 {
+		return a.queryBackward(ctx, w, parts, qq, -limit)
 	}
 
-	if i == len(a.subs) || a.subs[i].id != id {
-		return ErrUnknownSubscription
+	return a.queryForward(ctx, w, parts, qq, limit)
+}
+
+func (a *Agent) queryForward(ctx context.Context, w io.Writer, parts []partition, q *query, limit int) error {
+	var n int
+	var last int64
+
+	for _, part := range parts {
+		done, err := a.mergePartition(ctx, part, q, func(ts int64, p []byte) (bool, error) {
+			if limit != 0 && n >= limit && ts != last {
+				return false, nil
+			}
+
+			n++
+			last = ts
+
+			_, err := w.Write(p)
+			if err != nil {
+				return false, errors.Wrap(err, "write")
+			}
+
+			return true, nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "partition %v", filepath.Base(part.dir))
+		}
+
+		if done {
+			return nil
+		}
 	}
 
-	copy(a.subs[i:], a.subs[i+1:])
+	return nil
+}
+
+// queryBackward writes the last limit events.
+// Partitions are scanned from the newest one until enough events are collected.
+func (a *Agent) queryBackward(ctx context.Context, w io.Writer, parts []partition, q *query, limit int) error {
+	var page []qevent
+
+	for k := len(parts) - 1; k >= 0 && len(page) < limit; k-- {
+		need := limit - len(page)
+
+		var evs []qevent
+
+		_, err := a.mergePartition(ctx, parts[k], q, func(ts int64, p []byte) (bool, error) {
+			evs = append(evs, qevent{
+				ts: ts,
+				p:  append([]byte{}, p...),
+			})
+
+			// drop the earliest timestamp groups while enough events remain
+			for {
+				g := 1
+				for g < len(evs) && evs[g].ts == evs[0].ts {
+					g++
+				}
+
+				if len(evs)-g < need {
+					break
+				}
 
-	a.subs = a.subs[:len(a.subs)-1]
+				evs = evs[g:]
+			}
+
+			return true, nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "partition %v", filepath.Base(parts[k].dir))
+		}
+
+		page = append(evs, page...)
+	}
+
+	for _, ev := range page {
+		_, err := w.Write(ev.p)
+		if err != nil {
+			return errors.Wrap(err, "write")
+		}
+	}
 
 	return nil
 }
+
+// mergePartition calls f for each matching event of the partition in timestamp order.
+// f returns false to stop, in which case mergePartition returns done == true.
+func (a *Agent) mergePartition(ctx context.Context, part partition, q *query, f func(ts int64, p []byte) (bool, error)) (done bool, err error) {
+	m, err := a.newMerger(ctx, part, q)
+	if err != nil {
+		return false, err
+	}
+
+	defer hnet.Closer(m, &err, "close files")
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return false, err
+		}
+
+		ts, p, err := m.Next()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		ok, err := f(ts, p)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			return true, nil
+		}
+	}
+}
+
+// partitions returns db partitions ordered by time.
+func (a *Agent) partitions() ([]partition, error) {
+	dirs, err := os.ReadDir(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]partition, 0, len(dirs))
+
+	for _, d := range dirs {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+
+		t, err := time.Parse(partitionFormat, d.Name())
+		if err != nil {
+			continue
+		}
+
+		parts = append(parts, partition{
+			dir:   filepath.Join(a.path, d.Name()),
+			start: t.UnixNano(),
+		})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].start < parts[j].start
+	})
+
+	return parts, nil
+}
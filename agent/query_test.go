@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestQueryPage(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	a.Partition = time.Hour
+	a.BlockSize = 256
+
+	base := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	now := base
+
+	var ls []*tlog.Logger
+
+	for j := range 3 {
+		l := tlog.New(a)
+		tlog.LoggerSetTimeNow(l, func() time.Time { return now }, func() int64 { return now.UnixNano() })
+		l.SetLabels("stream", j)
+
+		ls = append(ls, l)
+	}
+
+	for i := range 30 {
+		now = base.Add(time.Duration(i/3) * 20 * time.Minute) // 3 events share each timestamp
+
+		ls[i%3].Printw("message", "i", i)
+	}
+
+	type ev struct {
+		ts int64
+		i  int64
+	}
+
+	query := func(cursor int64, limit int) (r []ev) {
+		var out low.Buf
+
+		err := a.QueryPage(context.Background(), &out, 0, cursor, limit, "")
+		assert.NoError(t, err)
+
+		var d tlwire.Decoder
+
+		for i := 0; i < len(out); i = d.Skip(out, i) {
+			ts, _, _, err := a.parseEventHeader(out[i:])
+			assert.NoError(t, err)
+
+			r = append(r, ev{ts: ts, i: geti(out[i:])})
+		}
+
+		return r
+	}
+
+	all := query(0, 0)
+	assert.Equal(t, 30, len(all))
+
+	for k := 1; k < len(all); k++ {
+		assert.True(t, all[k-1].ts <= all[k].ts, "order: %v", all)
+	}
+
+	var fwd []ev
+
+	for cursor := int64(0); ; {
+		page := query(cursor, 4)
+		if len(page) == 0 {
+			break
+		}
+
+		assert.Equal(t, 6, len(page), "groups are not split")
+
+		fwd = append(fwd, page...)
+		cursor = page[len(page)-1].ts + 1
+	}
+
+	assert.Equal(t, all, fwd)
+
+	var back []ev
+
+	for cursor := int64(0); ; {
+		page := query(cursor, -4)
+		if len(page) == 0 {
+			break
+		}
+
+		assert.Equal(t, 6, len(page), "groups are not split")
+
+		back = append(page, back...)
+		cursor = page[0].ts
+	}
+
+	assert.Equal(t, all, back)
+
+	is := func(evs []ev) (r []int64) {
+		for _, e := range evs {
+			r = append(r, e.i)
+		}
+
+		slices.Sort(r)
+
+		return r
+	}
+
+	page := query(base.Add(90*time.Minute).UnixNano(), 3)
+	assert.Equal(t, []int64{15, 16, 17}, is(page))
+
+	page = query(base.Add(90*time.Minute).UnixNano(), -3)
+	assert.Equal(t, []int64{12, 13, 14}, is(page))
+}
@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"io"
+	"os"
+
+	"nikand.dev/go/hacked/hnet"
+	"tlog.app/go/eazy"
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	delayedErr struct {
+		io.Reader
+
+		err error
+	}
+)
+
+// recoverTail truncates the file after the last complete event.
+// The tail may be torn if the agent died in the middle of a write
+// or after padding the file for a new block.
+// Index entries pointing beyond the new end are dropped.
+//
+// Only the last indexed block is checked, or the whole file if there is no index.
+// Index entry is written before the block data, so it's at least as recent as the data.
+func (a *Agent) recoverTail(f *file) (err error) {
+	r, err := os.Open(f.name)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+
+	defer hnet.Closer(r, &err, "close")
+
+	inf, err := r.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat")
+	}
+
+	size := inf.Size()
+
+	var st int64
+
+	for _, e := range f.index {
+		if e.off < size {
+			st = e.off
+		}
+	}
+
+	end, err := validTail(io.NewSectionReader(r, st, size-st))
+	if err != nil {
+		return errors.Wrap(err, "read tail")
+	}
+
+	end += st
+
+	if end < size {
+		a.Logger.Printw("truncate torn tail", "file", f.name, "size", size, "end", end)
+
+		err = os.Truncate(f.name, end)
+		if err != nil {
+			return errors.Wrap(err, "truncate")
+		}
+	}
+
+	idx := f.index
+
+	for len(idx) != 0 && idx[len(idx)-1].off >= end {
+		idx = idx[:len(idx)-1]
+	}
+
+	if len(idx) == len(f.index) {
+		return nil
+	}
+
+	f.index = idx
+
+	var buf []byte
+
+	for _, e := range idx {
+		buf = appendIndex(buf, e)
+	}
+
+	err = os.WriteFile(f.name+indexExt, buf, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "rewrite index")
+	}
+
+	return nil
+}
+
+// validTail returns the length of the compressed data prefix
+// containing only complete events.
+// Each event is written with a separate eazy.Writer.Write call,
+// so event ends are aligned with compressed tag ends.
+//
+// Data is read twice: to find the decompressed end of the last complete event
+// and to find the compressed tag ending there.
+// Neither pass holds more than one event or one read buffer in memory.
+func validTail(r *io.SectionReader) (int64, error) {
+	var last int64 // decompressed end of the last complete event
+
+	tr := tlwire.NewReader(&delayedErr{Reader: eazy.NewReader(r)}) // data is valid until the first error
+
+	for {
+		p, err := tr.ReadOne()
+		if err != nil {
+			break
+		}
+
+		last += int64(len(p))
+	}
+
+	if last == 0 {
+		return 0, nil
+	}
+
+	var d eazy.Decoder
+	var pos, valid int64
+
+	b := make([]byte, 0, 0x10000)
+	var boff int64 // b offset in r
+	var i int
+	eof := false
+
+	for {
+		// longest tag header with an offset is less than 32 bytes
+		if len(b)-i < 32 && !eof {
+			n := copy(b[:cap(b)], b[i:])
+			boff += int64(i)
+			i = 0
+
+			m, err := r.ReadAt(b[n:cap(b)], boff+int64(n))
+			if errors.Is(err, io.EOF) {
+				eof = true
+			} else if err != nil {
+				return 0, err
+			}
+
+			b = b[:n+m]
+		}
+
+		if i == len(b) {
+			break
+		}
+
+		if b[i] == eazy.Padding {
+			i++
+			continue
+		}
+
+		tag, l, j, err := d.Tag(b, i)
+		if err != nil {
+			break
+		}
+
+		switch {
+		case tag == eazy.Meta && l == 0:
+			var ml int
+
+			_, ml, j, err = d.Meta(b, j)
+			j += ml
+		case tag == eazy.Literal:
+			j += l
+			pos += int64(l)
+		case tag == eazy.Copy:
+			_, j, err = d.Offset(b, j, l)
+			pos += int64(l)
+		}
+
+		if err != nil || boff+int64(j) > r.Size() || pos > last {
+			break
+		}
+
+		if j > len(b) { // skip literal or meta data not in the buffer
+			boff += int64(j)
+			b = b[:0]
+			i = 0
+		} else {
+			i = j
+		}
+
+		if tag == eazy.Literal || tag == eazy.Copy && l != 0 {
+			valid = boff + int64(i)
+		}
+	}
+
+	return valid, nil
+}
+
+// Read returns the error only after all the data read before it is consumed.
+func (r *delayedErr) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err = r.Reader.Read(p)
+	if n != 0 && err != nil {
+		r.err, err = err, nil
+	}
+
+	return n, err
+}
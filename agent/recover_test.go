@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestRecoverTail(t *testing.T) {
+	base := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	write := func(a *Agent, from, to int) {
+		l := tlog.New(a)
+		l.SetLabels("service", "api")
+
+		for i := from; i < to; i++ {
+			now := base.Add(time.Duration(i) * time.Second).UnixNano()
+			tlog.LoggerSetTimeNow(l, func() time.Time { return time.Unix(0, now) }, func() int64 { return now })
+
+			l.Printw("message", "i", i, "payload", "some repeated payload")
+		}
+	}
+
+	is := func(a *Agent) (r []int64) {
+		var out low.Buf
+
+		err := a.Query(context.Background(), &out, 0, "")
+		assert.NoError(t, err)
+
+		var d tlwire.Decoder
+
+		for i := 0; i < len(out); i = d.Skip(out, i) {
+			r = append(r, geti(out[i:]))
+		}
+
+		return r
+	}
+
+	for _, tc := range []struct {
+		name   string
+		damage func(t *testing.T, f *file)
+		events int
+	}{
+		{"torn_event", func(t *testing.T, f *file) {
+			err := os.Truncate(f.name, f.off-3)
+			assert.NoError(t, err)
+		}, 19},
+		{"padded_block", func(t *testing.T, f *file) {
+			next := f.off + 256 - f.off%256
+
+			err := os.Truncate(f.name, next)
+			assert.NoError(t, err)
+
+			w, err := os.OpenFile(f.name+indexExt, os.O_APPEND|os.O_WRONLY, 0)
+			assert.NoError(t, err)
+
+			_, err = w.Write(appendIndex(nil, ientry{off: next, ts: base.Add(time.Minute).UnixNano()}))
+			assert.NoError(t, err)
+
+			err = w.Close()
+			assert.NoError(t, err)
+		}, 20},
+		{"garbage", func(t *testing.T, f *file) {
+			w, err := os.OpenFile(f.name, os.O_APPEND|os.O_WRONLY, 0)
+			assert.NoError(t, err)
+
+			_, err = w.Write([]byte{0x0f, 'a', 'b'})
+			assert.NoError(t, err)
+
+			err = w.Close()
+			assert.NoError(t, err)
+		}, 20},
+		{"no_index", func(t *testing.T, f *file) {
+			err := os.Truncate(f.name, f.off-3)
+			assert.NoError(t, err)
+
+			err = os.Remove(f.name + indexExt)
+			assert.NoError(t, err)
+		}, 19},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			a, err := New(dir)
+			assert.NoError(t, err)
+
+			a.BlockSize = 256
+
+			write(a, 0, 20)
+
+			f := a.streams[0].file
+			tc.damage(t, f)
+
+			a, err = New(dir)
+			assert.NoError(t, err)
+
+			a.BlockSize = 256
+
+			assert.Equal(t, tc.events, len(is(a)))
+
+			write(a, 20, 25)
+
+			exp := make([]int64, 0, tc.events+5)
+
+			for i := range 25 {
+				if i < tc.events || i >= 20 {
+					exp = append(exp, int64(i))
+				}
+			}
+
+			assert.Equal(t, exp, is(a))
+
+			idx, err := readIndex(f.name)
+			assert.NoError(t, err)
+
+			for i := 1; i < len(idx); i++ {
+				assert.True(t, idx[i-1].off < idx[i].off && idx[i-1].ts <= idx[i].ts, "index: %v", idx)
+			}
+		})
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for s, exp := range map[string]SyncPolicy{
+		"always":   SyncAlways,
+		"interval": SyncInterval,
+		"never":    SyncNever,
+	} {
+		p, err := ParseSyncPolicy(s)
+		assert.NoError(t, err)
+		assert.Equal(t, exp, p)
+	}
+
+	_, err := ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
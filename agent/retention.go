@@ -0,0 +1,405 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nikand.dev/go/hacked/hnet"
+	"tlog.app/go/eazy"
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	dbfile struct {
+		name string
+		part int64
+		ts   int64
+		size int64
+
+		sum    uint32
+		active bool
+	}
+)
+
+// Cleanup removes old files exceeding MaxTotalAge, MaxStreamSize, and MaxTotalSize limits
+// and then merges small files of closed partitions if CompactFileSize is set.
+// Files currently being written are never removed.
+func (a *Agent) Cleanup(ctx context.Context, now int64) error {
+	files, err := a.dbFiles()
+	if err != nil {
+		return errors.Wrap(err, "list files")
+	}
+
+	files, err = a.removeOld(files, now)
+	if err != nil {
+		return errors.Wrap(err, "remove old")
+	}
+
+	if a.CompactFileSize == 0 {
+		return nil
+	}
+
+	err = a.compact(ctx, files, now)
+	if err != nil {
+		return errors.Wrap(err, "compact")
+	}
+
+	return nil
+}
+
+// dbFiles returns all the db files ordered from the oldest to the newest.
+func (a *Agent) dbFiles() ([]dbfile, error) {
+	active := map[string]bool{}
+
+	for _, s := range a.streamsList() {
+		s.mu.Lock()
+
+		if s.file != nil {
+			active[s.file.name] = true
+		}
+
+		s.mu.Unlock()
+	}
+
+	parts, err := a.partitions()
+	if err != nil {
+		return nil, errors.Wrap(err, "list partitions")
+	}
+
+	var files []dbfile
+
+	for _, part := range parts {
+		ents, err := os.ReadDir(part.dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "read dir")
+		}
+
+		for _, e := range ents {
+			if e.IsDir() || filepath.Ext(e.Name()) != ".tlz" {
+				continue
+			}
+
+			inf, err := e.Info()
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "stat")
+			}
+
+			f := dbfile{
+				name: filepath.Join(part.dir, e.Name()),
+				part: part.start,
+				size: inf.Size(),
+			}
+
+			f.active = active[f.name]
+
+			base := strings.TrimSuffix(e.Name(), ".tlz")
+
+			if _, err = fmt.Sscanf(base, "%08x_%08x", &f.sum, &f.ts); err != nil {
+				continue
+			}
+
+			f.ts *= 1e9
+
+			files = append(files, f)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].part != files[j].part {
+			return files[i].part < files[j].part
+		}
+
+		return files[i].ts < files[j].ts
+	})
+
+	return files, nil
+}
+
+// removeOld removes files exceeding limits and returns the rest.
+func (a *Agent) removeOld(files []dbfile, now int64) (_ []dbfile, err error) {
+	remove := make([]bool, len(files))
+
+	if a.MaxTotalAge != 0 {
+		for i, f := range files {
+			if f.part+int64(a.Partition) <= now-int64(a.MaxTotalAge) {
+				remove[i] = true
+			}
+		}
+	}
+
+	if a.MaxStreamSize != 0 {
+		streams := map[uint32]int64{}
+
+		for i := len(files) - 1; i >= 0; i-- {
+			f := files[i]
+			if remove[i] {
+				continue
+			}
+
+			streams[f.sum] += f.size
+
+			if streams[f.sum] > a.MaxStreamSize {
+				remove[i] = true
+			}
+		}
+	}
+
+	if a.MaxTotalSize != 0 {
+		var total int64
+
+		for i := len(files) - 1; i >= 0; i-- {
+			if remove[i] {
+				continue
+			}
+
+			total += files[i].size
+
+			if total > a.MaxTotalSize {
+				remove[i] = true
+			}
+		}
+	}
+
+	keep := files[:0]
+
+	for i, f := range files {
+		if !remove[i] || f.active {
+			keep = append(keep, f)
+			continue
+		}
+
+		a.Logger.Printw("remove db file", "file", f.name, "size", f.size)
+
+		err = removeFile(f.name)
+		if err != nil {
+			return nil, errors.Wrap(err, "remove %v", filepath.Base(f.name))
+		}
+
+		_ = os.Remove(filepath.Dir(f.name)) // remove partition dir if empty
+	}
+
+	return keep, nil
+}
+
+// compact merges small files of each stream in each closed partition
+// into one file with events sorted by timestamp.
+func (a *Agent) compact(ctx context.Context, files []dbfile, now int64) error {
+	for i := 0; i < len(files); {
+		part := files[i].part
+
+		j := i
+		for j < len(files) && files[j].part == part {
+			j++
+		}
+
+		if part+int64(a.Partition) > now {
+			break
+		}
+
+		var sums []uint32
+		small := map[uint32][]dbfile{}
+		size := map[uint32]int64{}
+
+		for _, f := range files[i:j] {
+			if f.active || f.size >= a.CompactFileSize || size[f.sum]+f.size > a.FileSize/2 {
+				continue
+			}
+
+			if _, ok := small[f.sum]; !ok {
+				sums = append(sums, f.sum)
+			}
+
+			small[f.sum] = append(small[f.sum], f)
+			size[f.sum] += f.size
+		}
+
+		i = j
+
+		for _, sum := range sums {
+			if len(small[sum]) < 2 {
+				continue
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			err := a.mergeFiles(part, small[sum])
+			if err != nil {
+				return errors.Wrap(err, "partition %v", time.Unix(0, part).UTC().Format(partitionFormat))
+			}
+		}
+	}
+
+	return nil
+}
+
+// mergeFiles merges files of the same stream replacing the oldest one.
+func (a *Agent) mergeFiles(part int64, files []dbfile) (err error) {
+	var evs []qevent
+
+	for _, f := range files {
+		evs, err = a.readEvents(evs, f.name)
+		if err != nil {
+			return errors.Wrap(err, "read %v", filepath.Base(f.name))
+		}
+	}
+
+	if len(evs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(evs, func(i, j int) bool {
+		return evs[i].ts < evs[j].ts
+	})
+
+	name := files[0].name
+	tmp := name + ".tmp"
+
+	err = a.writeMerged(tmp, part, evs)
+	if err != nil {
+		_ = removeFile(tmp)
+		return errors.Wrap(err, "write merged")
+	}
+
+	// file without index is valid, file with a wrong index is not
+	err = os.Remove(name + indexExt)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "remove index")
+	}
+
+	err = os.Rename(tmp, name)
+	if err != nil {
+		return errors.Wrap(err, "rename")
+	}
+
+	err = os.Rename(tmp+indexExt, name+indexExt)
+	if err != nil {
+		return errors.Wrap(err, "rename index")
+	}
+
+	a.Logger.Printw("merged db files", "file", name, "files", len(files), "events", len(evs))
+
+	for _, f := range files {
+		if f.name == name {
+			continue
+		}
+
+		err = removeFile(f.name)
+		if err != nil {
+			return errors.Wrap(err, "remove %v", filepath.Base(f.name))
+		}
+	}
+
+	return nil
+}
+
+func (a *Agent) readEvents(evs []qevent, name string) (_ []qevent, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return evs, errors.Wrap(err, "open")
+	}
+
+	defer hnet.Closer(f, &err, "close")
+
+	r := tlwire.NewReader(eazy.NewReader(f))
+
+	for {
+		p, err := r.ReadOne()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return evs, nil
+		}
+		if err != nil {
+			return evs, errors.Wrap(err, "read event")
+		}
+
+		ts, _, _, err := a.parseEventHeader(p)
+		if err != nil {
+			return evs, errors.Wrap(err, "parse event")
+		}
+
+		evs = append(evs, qevent{
+			ts: ts,
+			p:  append([]byte{}, p...),
+		})
+	}
+}
+
+func (a *Agent) writeMerged(name string, part int64, evs []qevent) (err error) {
+	f := &file{
+		name: name,
+		part: part,
+		ts:   evs[0].ts,
+	}
+
+	err = a.openFile(f)
+	if err != nil {
+		return err
+	}
+
+	defer hnet.Closer(f, &err, "close")
+
+	s := &stream{
+		file: f,
+	}
+
+	s.z = eazy.NewWriter(&s.zbuf, eazy.MiB, 1024)
+	s.z.AppendMagic = true
+
+	for _, ev := range evs {
+		s.zbuf = s.zbuf[:0]
+
+		_, err = s.z.Write(ev.p)
+		if err != nil {
+			return errors.Wrap(err, "eazy")
+		}
+
+		_, err = a.writeBlock(s, f, ev.p, ev.ts)
+		if err != nil {
+			return errors.Wrap(err, "write")
+		}
+	}
+
+	if a.Sync != SyncNever {
+		err = f.sync()
+		if err != nil {
+			return errors.Wrap(err, "sync")
+		}
+	}
+
+	return nil
+}
+
+func (f *file) Close() (err error) {
+	if c, ok := f.w.(io.Closer); ok {
+		err = c.Close()
+	}
+
+	if c, ok := f.idx.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+func removeFile(name string) error {
+	err := os.Remove(name + indexExt)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return os.Remove(name)
+}
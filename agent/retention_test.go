@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	a, err := New(dir)
+	assert.NoError(t, err)
+
+	a.Partition = time.Hour
+	a.FileSize = 1 // file per event
+
+	now := base
+
+	newLogger := func(service string) *tlog.Logger {
+		l := tlog.New(a)
+		tlog.LoggerSetTimeNow(l, func() time.Time { return now }, func() int64 { return now.UnixNano() })
+		l.SetLabels("service", service)
+
+		return l
+	}
+
+	l1 := newLogger("one")
+	l2 := newLogger("two")
+
+	for i := range 6 {
+		now = base.Add(time.Duration(i) * 20 * time.Minute)
+
+		l1.Printw("message", "i", 2*i)
+
+		now = now.Add(time.Minute)
+		l2.Printw("message", "i", 2*i+1)
+	}
+
+	files := func() (r []string) {
+		fs, err := a.dbFiles()
+		assert.NoError(t, err)
+
+		for _, f := range fs {
+			labels, err := a.readLabels(f.name)
+			assert.NoError(t, err)
+
+			r = append(r, filepath.Base(filepath.Dir(f.name))+"/"+labelsString(labels))
+		}
+
+		return r
+	}
+
+	is := func() (r []int64) {
+		var out low.Buf
+
+		err := a.Query(context.Background(), &out, 0, "")
+		assert.NoError(t, err)
+
+		var d tlwire.Decoder
+
+		for i := 0; i < len(out); i = d.Skip(out, i) {
+			r = append(r, geti(out[i:]))
+		}
+
+		return r
+	}
+
+	assert.Equal(t, 12, len(files()))
+
+	a.FileSize = 1 << 20
+	a.CompactFileSize = 1 << 20
+
+	err = a.Cleanup(context.Background(), now.UnixNano())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"2025-03-01T12:00/service=one",
+		"2025-03-01T12:00/service=two",
+		"2025-03-01T13:00/service=one",
+		"2025-03-01T13:00/service=two",
+		"2025-03-01T13:00/service=one",
+		"2025-03-01T13:00/service=two",
+		"2025-03-01T13:00/service=one",
+		"2025-03-01T13:00/service=two",
+	}, files())
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, is())
+
+	ents, err := os.ReadDir(filepath.Join(dir, "2025-03-01T12:00"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(ents), "stream files and their indexes only")
+
+	a.MaxTotalAge = 30 * time.Minute
+
+	err = a.Cleanup(context.Background(), base.Add(90*time.Minute).UnixNano())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 6, len(files()))
+	assert.Equal(t, []string(nil), deletedOpen(t, dir), "removed files are closed")
+
+	_, err = os.Stat(filepath.Join(dir, "2025-03-01T12:00"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	a.MaxTotalAge = 0
+	a.MaxStreamSize = 1
+
+	err = a.Cleanup(context.Background(), now.UnixNano())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, len(files()), "active files are kept")
+	assert.Equal(t, []string(nil), deletedOpen(t, dir), "removed files are closed")
+}
+
+// deletedOpen returns removed files under dir the process still holds open.
+func deletedOpen(t *testing.T, dir string) (r []string) {
+	t.Helper()
+
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("no /proc: %v", err)
+	}
+
+	for _, fd := range fds {
+		name, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(name, dir) && strings.HasSuffix(name, " (deleted)") {
+			r = append(r, name)
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"tlog.app/go/errors"
+)
+
+type (
+	// SlowPolicy is what to do with a subscriber which can't keep up with ingestion.
+	SlowPolicy int
+
+	sub struct {
+		io.Writer
+
+		id int64
+		q  *query
+
+		queue   chan []byte
+		dropped int64
+	}
+)
+
+// Slow subscriber policies.
+const (
+	// DropEvents drops new events while subscriber queue is full.
+	DropEvents SlowPolicy = iota
+
+	// Disconnect cancels the subscription once its queue is full.
+	Disconnect
+)
+
+// Subscribe sends each new event matching q to w.
+// Events are queued and written from a separate goroutine,
+// so a slow w never blocks ingestion.
+// See SubQueueSize and SlowSubscriber for what happens when the queue is full.
+//
+// Subscription is active until Unsubscribe is called, ctx is canceled, or w returns an error.
+func (a *Agent) Subscribe(ctx context.Context, w io.Writer, q string) (int64, error) {
+	qq, err := parseQuery(q, a.KeyTimestamp, time.Now().UnixNano())
+	if err != nil {
+		return 0, errors.Wrap(err, "parse query")
+	}
+
+	defer a.submu.Unlock()
+	a.submu.Lock()
+
+	a.subid++
+
+	s := &sub{
+		Writer: w,
+		id:     a.subid,
+		q:      qq,
+		queue:  make(chan []byte, a.SubQueueSize),
+	}
+
+	a.subs = append(a.subs, s)
+
+	go a.runSub(ctx, s)
+
+	return s.id, nil
+}
+
+// Unsubscribe cancels the subscription.
+// Events already queued are still written.
+func (a *Agent) Unsubscribe(ctx context.Context, id int64) error {
+	defer a.submu.Unlock()
+	a.submu.Lock()
+
+	return a.unsubscribe(id, nil)
+}
+
+func (a *Agent) unsubscribe(id int64, reason error) error {
+	i := 0
+
+	for i < len(a.subs) && a.subs[i].id < id {
+		i++
+	}
+
+	if i == len(a.subs) || a.subs[i].id != id {
+		return ErrUnknownSubscription
+	}
+
+	s := a.subs[i]
+
+	copy(a.subs[i:], a.subs[i+1:])
+	a.subs[len(a.subs)-1] = nil
+	a.subs = a.subs[:len(a.subs)-1]
+
+	close(s.queue)
+
+	if reason != nil || s.dropped != 0 {
+		a.Logger.Printw("subscription closed", "id", s.id, "reason", reason, "dropped", s.dropped)
+	}
+
+	return nil
+}
+
+func (a *Agent) runSub(ctx context.Context, s *sub) {
+	for {
+		var p []byte
+		var ok bool
+
+		select {
+		case <-ctx.Done():
+			a.submu.Lock()
+			_ = a.unsubscribe(s.id, ctx.Err())
+			a.submu.Unlock()
+
+			return
+		case p, ok = <-s.queue:
+		}
+
+		if !ok {
+			return
+		}
+
+		_, err := s.Write(p)
+		if err != nil {
+			a.submu.Lock()
+			_ = a.unsubscribe(s.id, err)
+			a.submu.Unlock()
+
+			return
+		}
+	}
+}
+
+// notify sends the event to matching subscribers.
+func (a *Agent) notify(p []byte) {
+	defer a.submu.Unlock()
+	a.submu.Lock()
+
+	for i := 0; i < len(a.subs); {
+		s := a.subs[i]
+
+		if _, ok := s.q.match(p); !ok {
+			i++
+			continue
+		}
+
+		select {
+		case s.queue <- append([]byte{}, p...):
+			i++
+			continue
+		default:
+		}
+
+		if a.SlowSubscriber == Disconnect {
+			_ = a.unsubscribe(s.id, ErrSlowSubscriber)
+			continue
+		}
+
+		s.dropped++
+		i++
+	}
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+
+	"tlog.app/go/tlog"
+)
+
+type chanWriter chan []byte
+
+func TestSubscribe(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+
+	w := make(chanWriter, 10)
+
+	id, err := a.Subscribe(ctx, w, `{service=api} i>=2`)
+	assert.NoError(t, err)
+
+	l := tlog.New(a)
+	l.SetLabels("service", "api")
+
+	other := tlog.New(a)
+	other.SetLabels("service", "db")
+
+	for i := range 4 {
+		l.Printw("message", "i", i)
+		other.Printw("message", "i", i)
+	}
+
+	for _, exp := range []int64{2, 3} {
+		select {
+		case p := <-w:
+			assert.Equal(t, exp, geti(p))
+		case <-time.After(time.Second):
+			t.Fatalf("event %d was not delivered", exp)
+		}
+	}
+
+	select {
+	case p := <-w:
+		t.Errorf("unexpected event: %d", geti(p))
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	err = a.Unsubscribe(ctx, id)
+	assert.NoError(t, err)
+
+	err = a.Unsubscribe(ctx, id)
+	assert.ErrorIs(t, err, ErrUnknownSubscription)
+}
+
+func TestSubscribeSlow(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	a.SubQueueSize = 1
+	a.SlowSubscriber = Disconnect
+
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	mu.Lock()
+
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(p), nil
+	})
+
+	id, err := a.Subscribe(ctx, w, "")
+	assert.NoError(t, err)
+
+	l := tlog.New(a)
+
+	for i := range 4 {
+		l.Printw("message", "i", i) // must not block
+	}
+
+	mu.Unlock()
+
+	err = a.Unsubscribe(ctx, id)
+	assert.ErrorIs(t, err, ErrUnknownSubscription)
+}
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- append([]byte{}, p...)
+
+	return len(p), nil
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"time"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	spanSet map[tlog.ID]struct{}
+)
+
+// Trace writes events of the span id and all of its descendant spans to w ordered by timestamp.
+// Child spans are found through KeyParent of their start events.
+// q is applied to all the events as in Query, it's usually used to narrow the time range.
+//
+// Events are scanned in timestamp order, so a child is usually discovered before its own events.
+// If it's not, for example if clocks of services differ, the scan is repeated.
+//
+// The scan is bounded to Agent.MaxTraceDuration after the first trace event
+// and stops after the span id finish event.
+// Events written before the first trace event or after the span finish are not found.
+func (a *Agent) Trace(ctx context.Context, w io.Writer, id tlog.ID, q string) error {
+	if id == (tlog.ID{}) {
+		return errors.New("empty trace id")
+	}
+
+	qq, err := parseQuery(q, a.KeyTimestamp, time.Now().UnixNano())
+	if err != nil {
+		return errors.Wrap(err, "parse query")
+	}
+
+	parts, err := a.partitions()
+	if err != nil {
+		return errors.Wrap(err, "list partitions")
+	}
+
+	spans := spanSet{id: {}}
+
+	var evs []qevent
+	var first, end, finish int64 // trace window
+
+	for {
+		var skipped spanSet           // spans with events skipped
+		var links map[tlog.ID]tlog.ID // skipped child to parent links
+		var missed bool
+
+		evs = evs[:0]
+
+		for _, part := range parts {
+			if qq.to != 0 && part.start >= qq.to {
+				break
+			}
+
+			if qq.from != 0 && part.start+int64(a.Partition) <= qq.from {
+				continue
+			}
+
+			done, err := a.mergePartition(ctx, part, qq, func(ts int64, p []byte) (bool, error) {
+				if end != 0 && ts > end || finish != 0 && ts > finish {
+					return false, nil
+				}
+
+				s, par, kind := spanIDs(p)
+
+				_, ok := spans[s]
+
+				if !ok && par != (tlog.ID{}) {
+					if _, ok = spans[par]; ok {
+						spans[s] = struct{}{}
+
+						_, seen := skipped[s]
+						missed = missed || seen
+					}
+				}
+
+				if !ok {
+					if s == (tlog.ID{}) || first == 0 {
+						return true, nil
+					}
+
+					if skipped == nil {
+						skipped = spanSet{}
+						links = map[tlog.ID]tlog.ID{}
+					}
+
+					skipped[s] = struct{}{}
+
+					if par != (tlog.ID{}) {
+						links[s] = par
+					}
+
+					return true, nil
+				}
+
+				if first == 0 {
+					first = ts
+
+					if a.MaxTraceDuration != 0 {
+						end = first + int64(a.MaxTraceDuration)
+					}
+				}
+
+				if s == id && kind == tlog.EventSpanFinish {
+					finish = ts
+				}
+
+				evs = append(evs, qevent{
+					ts: ts,
+					p:  append([]byte{}, p...),
+				})
+
+				return true, nil
+			})
+			if err != nil {
+				return errors.Wrap(err, "partition %v", filepath.Base(part.dir))
+			}
+
+			if done {
+				break
+			}
+		}
+
+		// children started before their parents were discovered
+		for changed := true; changed; {
+			changed = false
+
+			for c, par := range links {
+				if _, ok := spans[par]; !ok {
+					continue
+				}
+
+				if _, ok := spans[c]; !ok {
+					spans[c] = struct{}{}
+					changed = true
+					missed = true
+				}
+			}
+		}
+
+		if !missed {
+			break
+		}
+
+		qq.from = max(qq.from, first)
+	}
+
+	for _, ev := range evs {
+		_, err = w.Write(ev.p)
+		if err != nil {
+			return errors.Wrap(err, "write")
+		}
+	}
+
+	return nil
+}
+
+// spanIDs returns KeySpan, KeyParent, and KeyEventKind event values.
+func spanIDs(p []byte) (s, par tlog.ID, kind tlog.EventKind) {
+	var d tlwire.Decoder
+
+	tag, els, i := d.Tag(p, 0)
+	if tag != tlwire.Map {
+		return
+	}
+
+	var k []byte
+
+	for el := 0; els == -1 || el < int(els); el++ {
+		if els == -1 && d.Break(p, &i) {
+			break
+		}
+
+		k, i = d.Bytes(p, i)
+
+		switch {
+		case string(k) == tlog.KeySpan && p[i] == byte(tlwire.Semantic|tlog.WireID):
+			i = s.TlogParse(p, i)
+		case string(k) == tlog.KeyParent && p[i] == byte(tlwire.Semantic|tlog.WireID):
+			i = par.TlogParse(p, i)
+		case string(k) == tlog.KeyEventKind && p[i] == byte(tlwire.Semantic|tlog.WireEventKind):
+			i = kind.TlogParse(p, i)
+		default:
+			i = d.Skip(p, i)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestTrace(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.NoError(t, err)
+
+	base := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	newLogger := func(service string, now *time.Time) *tlog.Logger {
+		l := tlog.New(a)
+		tlog.LoggerSetTimeNow(l, func() time.Time { return *now }, func() int64 { return now.UnixNano() })
+		l.SetLabels("service", service)
+
+		return l
+	}
+
+	var now1, now2 time.Time
+
+	api := newLogger("api", &now1)
+	db := newLogger("db", &now2)
+
+	now1 = base
+	root := api.Start("request")
+	other := api.Start("other")
+
+	now1 = base.Add(time.Second)
+	child := root.Spawn("child")
+	other.Printw("unrelated", "i", -1)
+
+	// db clock is behind, so its span is before its parent
+	now2 = base.Add(500 * time.Millisecond)
+	remote := db.NewSpan(-1, child.ID, "query")
+	remote.Printw("executing", "i", 1)
+
+	now1 = base.Add(2 * time.Second)
+	child.Printw("child message", "i", 2)
+	grand := child.Spawn("grand child")
+	grand.Finish()
+	child.Finish()
+
+	now1 = base.Add(3 * time.Second)
+	root.Printw("done", "i", 3)
+	root.Finish()
+	other.Finish()
+
+	now1 = base.Add(4 * time.Second)
+	child.Printw("after root finish", "i", 4)
+
+	var out low.Buf
+
+	err = a.Trace(context.Background(), &out, root.ID, "")
+	assert.NoError(t, err)
+
+	spans := map[tlog.ID]int{}
+	var msgs []int64
+
+	var d tlwire.Decoder
+
+	for i := 0; i < len(out); i = d.Skip(out, i) {
+		s, _, _ := spanIDs(out[i:])
+		spans[s]++
+
+		if x := geti(out[i:]); x != -1 {
+			msgs = append(msgs, x)
+		}
+	}
+
+	assert.Equal(t, map[tlog.ID]int{
+		root.ID:   3,
+		child.ID:  3,
+		remote.ID: 2,
+		grand.ID:  2,
+	}, spans)
+
+	assert.Equal(t, []int64{1, 2, 3}, msgs)
+
+	a.MaxTraceDuration = 1500 * time.Millisecond
+	out = out[:0]
+	msgs = msgs[:0]
+
+	err = a.Trace(context.Background(), &out, root.ID, "")
+	assert.NoError(t, err)
+
+	for i := 0; i < len(out); i = d.Skip(out, i) {
+		if x := geti(out[i:]); x != -1 {
+			msgs = append(msgs, x)
+		}
+	}
+
+	assert.Equal(t, []int64{1}, msgs)
+
+	err = a.Trace(context.Background(), &out, tlog.ID{}, "")
+	assert.Error(t, err)
+}
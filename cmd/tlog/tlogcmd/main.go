@@ -94,6 +94,14 @@ func App() *cli.Command {
 			cli.NewFlag("db-partition", 3*time.Hour, "db partition size"),
 			cli.NewFlag("db-file-size", int64(eazy.GiB), "db file size"),
 			cli.NewFlag("db-block-size", int64(16*eazy.MiB), "db file block size"),
+			cli.NewFlag("db-max-size", int64(0), "db max total size, 0 for no limit"),
+			cli.NewFlag("db-max-age", time.Duration(0), "db max age, 0 for no limit"),
+			cli.NewFlag("db-max-stream-size", int64(0), "db max size per label set, 0 for no limit"),
+			cli.NewFlag("db-compact-size", int64(0), "merge db files smaller than that in closed partitions, 0 to disable"),
+			cli.NewFlag("db-cleanup-interval", time.Minute, "db retention and compaction interval"),
+			cli.NewFlag("db-sync", "interval", "db fsync policy: always, interval, never"),
+			cli.NewFlag("db-sync-interval", time.Second, "db fsync interval"),
+			cli.NewFlag("db-metrics-interval", time.Minute, "agent metrics logging interval, 0 to disable"),
 
 			cli.NewFlag("clickdb", "", "clickhouse dsn"),
 
@@ -222,6 +230,18 @@ func agentRun(c *cli.Command) (err error) {
 		x.Partition = c.Duration("db-partition")
 		x.FileSize = c.Int64("db-file-size")
 		x.BlockSize = c.Int64("db-block-size")
+		x.MaxTotalSize = c.Int64("db-max-size")
+		x.MaxTotalAge = c.Duration("db-max-age")
+		x.MaxStreamSize = c.Int64("db-max-stream-size")
+		x.CompactFileSize = c.Int64("db-compact-size")
+		x.CleanupInterval = c.Duration("db-cleanup-interval")
+		x.SyncPeriod = c.Duration("db-sync-interval")
+		x.MetricsInterval = c.Duration("db-metrics-interval")
+
+		x.Sync, err = agent.ParseSyncPolicy(c.String("db-sync"))
+		if err != nil {
+			return errors.Wrap(err, "db-sync")
+		}
 
 		a = x
 	} else if q := c.String("clickdb"); q != "" {
@@ -244,6 +264,17 @@ func agentRun(c *cli.Command) (err error) {
 
 	group := graceful.New()
 
+	if x, ok := a.(*agent.Agent); ok {
+		group.Add(func(ctx context.Context) error {
+			err := x.Run(ctx)
+			if errors.Is(err, context.Canceled) {
+				err = nil
+			}
+
+			return err
+		})
+	}
+
 	if q := c.String("http"); q != "" {
 		l, err := net.Listen(c.String("http-net"), q)
 		if err != nil {
@@ -31,6 +31,7 @@ type (
 
 		addpad   int     // padding for the next pair
 		b, h     low.Buf // buf, header
+		mb       []byte  // message buf
 		lasttime low.Buf
 
 		ls, lastls []byte
@@ -228,6 +229,7 @@ more:
 	var lv LogLevel
 	var tp EventKind
 	var m []byte
+	var rep int64
 	w.ls = w.ls[:0]
 	b := w.b
 
@@ -255,6 +257,10 @@ more:
 		st := i
 
 		tag, sub, i = w.d.Tag(p, i)
+		if tag == tlwire.Int && string(k) == KeyRepeated {
+			rep = sub
+			continue
+		}
 		if tag != tlwire.Semantic {
 			b, i = w.appendPair(b, p, k, st)
 			continue
@@ -297,6 +303,11 @@ more:
 		}
 	}
 
+	if rep != 0 {
+		w.mb = fmt.Appendf(w.mb[:0], "%s (repeated %d times)", m, rep)
+		m = bytes.TrimLeft(w.mb, " ")
+	}
+
 	if include {
 		h = w.appendHeader(h, t, lv, pc, m, len(b))
 
@@ -35,17 +35,17 @@ func SpawnFromContext(ctx context.Context, name string, kvs ...interface{}) Span
 		return Span{}
 	}
 
-	return newspan(s.Logger, s.ID, 0, name, kvs)
+	return newspan(s.Logger, s.ID, s.ctx, 0, name, kvs)
 }
 
 func SpawnFromContextOrStart(ctx context.Context, name string, kvs ...interface{}) Span {
 	v := ctx.Value(ctxspankey{})
 	s, ok := v.(Span)
 	if ok {
-		return newspan(s.Logger, s.ID, 0, name, kvs)
+		return newspan(s.Logger, s.ID, s.ctx, 0, name, kvs)
 	}
 
-	return newspan(DefaultLogger, ID{}, 0, name, kvs)
+	return newspan(DefaultLogger, ID{}, "", 0, name, kvs)
 }
 
 func SpawnFromContextAndWrap(ctx context.Context, name string, kvs ...interface{}) (Span, context.Context) {
@@ -54,7 +54,7 @@ func SpawnFromContextAndWrap(ctx context.Context, name string, kvs ...interface{
 		return Span{}, ctx
 	}
 
-	s = newspan(s.Logger, s.ID, 0, name, kvs)
+	s = newspan(s.Logger, s.ID, s.ctx, 0, name, kvs)
 	ctx = context.WithValue(ctx, ctxspankey{}, s)
 
 	return s, ctx
@@ -0,0 +1,167 @@
+package convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// JSONReader reads a stream of JSON objects and converts them into tlwire events.
+	// Well-known keys like time, msg, level, and caller are mapped to tlog keys.
+	JSONReader struct {
+		eventReader
+
+		d *json.Decoder
+	}
+)
+
+func NewJSONReader(r io.Reader) *JSONReader {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+
+	x := &JSONReader{
+		d: d,
+	}
+
+	x.next = x.readEvent
+
+	return x
+}
+
+func (r *JSONReader) readEvent(b []byte) (_ []byte, err error) {
+	tok, err := r.d.Token()
+	if err != nil {
+		return b, err
+	}
+
+	if tok != json.Delim('{') {
+		return b, fmt.Errorf("object expected, got %v", tok)
+	}
+
+	b, err = r.appendObject(b, true)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return b, err
+}
+
+func (r *JSONReader) appendObject(b []byte, top bool) (_ []byte, err error) {
+	b = r.e.AppendMap(b, -1)
+
+	for {
+		tok, err := r.d.Token()
+		if err != nil {
+			return b, err
+		}
+
+		if tok == json.Delim('}') {
+			break
+		}
+
+		k, ok := tok.(string)
+		if !ok {
+			return b, fmt.Errorf("object key expected, got %v", tok)
+		}
+
+		tok, err = r.d.Token()
+		if err != nil {
+			return b, err
+		}
+
+		if top {
+			var v string
+
+			switch x := tok.(type) {
+			case string:
+				v, ok = x, true
+			case json.Number:
+				v, ok = string(x), true
+			default:
+				ok = false
+			}
+
+			if ok {
+				b, ok = r.appendWellKnown(b, k, v)
+			}
+
+			if ok {
+				continue
+			}
+		}
+
+		b = r.e.AppendString(b, k)
+
+		b, err = r.appendValue(b, tok)
+		if err != nil {
+			return b, err
+		}
+	}
+
+	return r.e.AppendBreak(b), nil
+}
+
+func (r *JSONReader) appendArray(b []byte) (_ []byte, err error) {
+	b = r.e.AppendArray(b, -1)
+
+	for r.d.More() {
+		tok, err := r.d.Token()
+		if err != nil {
+			return b, err
+		}
+
+		b, err = r.appendValue(b, tok)
+		if err != nil {
+			return b, err
+		}
+	}
+
+	_, err = r.d.Token() // ]
+	if err != nil {
+		return b, err
+	}
+
+	return r.e.AppendBreak(b), nil
+}
+
+func (r *JSONReader) appendValue(b []byte, tok json.Token) (_ []byte, err error) {
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			return r.appendObject(b, false)
+		case '[':
+			return r.appendArray(b)
+		}
+
+		return b, fmt.Errorf("unexpected delimiter: %v", v)
+	case string:
+		return r.e.AppendString(b, v), nil
+	case json.Number:
+		if x, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return r.e.AppendInt64(b, x), nil
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return b, err
+		}
+
+		return r.e.AppendFloat(b, f), nil
+	case bool:
+		if v {
+			return append(b, byte(tlwire.Special|tlwire.True)), nil
+		}
+
+		return append(b, byte(tlwire.Special|tlwire.False)), nil
+	case nil:
+		return append(b, byte(tlwire.Special|tlwire.Nil)), nil
+	}
+
+	return b, fmt.Errorf("unexpected token: %v", tok)
+}
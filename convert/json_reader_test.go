@@ -0,0 +1,129 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestJSONReader(t *testing.T) {
+	r := NewJSONReader(strings.NewReader(`{"a":1,"b":-2.5,"c":"str","d":[true,false,null],"e":{"f":"g","msg":"nested"}}
+{"msg":"second"}
+`))
+
+	var e tlwire.Encoder
+	var exp low.Buf
+
+	exp = e.AppendMap(exp, -1)
+	exp = e.AppendString(exp, "a")
+	exp = e.AppendInt64(exp, 1)
+	exp = e.AppendString(exp, "b")
+	exp = e.AppendFloat(exp, -2.5)
+	exp = e.AppendString(exp, "c")
+	exp = e.AppendString(exp, "str")
+	exp = e.AppendString(exp, "d")
+	exp = e.AppendArray(exp, -1)
+	exp = append(exp, byte(tlwire.Special|tlwire.True), byte(tlwire.Special|tlwire.False), byte(tlwire.Special|tlwire.Nil))
+	exp = e.AppendBreak(exp)
+	exp = e.AppendString(exp, "e")
+	exp = e.AppendMap(exp, -1)
+	exp = e.AppendString(exp, "f")
+	exp = e.AppendString(exp, "g")
+	exp = e.AppendString(exp, "msg")
+	exp = e.AppendString(exp, "nested")
+	exp = e.AppendBreak(exp)
+	exp = e.AppendBreak(exp)
+
+	b, err := r.ReadEvent()
+	require.NoError(t, err)
+	assert.Equal(t, exp, low.Buf(b))
+
+	var jb low.Buf
+
+	_, err = r.WriteTo(NewJSON(&jb))
+	require.NoError(t, err)
+	assert.Equal(t, `{"_m":"second"}`+"\n", string(jb))
+}
+
+func TestJSONReaderWellKnown(t *testing.T) {
+	r := NewJSONReader(strings.NewReader(`{"time":"2025-03-01T12:00:00.5Z","level":"warn","msg":"hello","caller":"path/file.go:10","a":1}
+{"ts":1740830400,"level":"nope","caller":"nope"}`))
+
+	b, err := r.ReadEvent()
+	require.NoError(t, err)
+
+	testWellKnown(t, b)
+
+	var jb low.Buf
+
+	j := NewJSON(&jb)
+	j.TimeZone = time.UTC
+
+	_, err = r.WriteTo(j)
+	require.NoError(t, err)
+	assert.Equal(t, `{"_t":"2025-03-01T12:00:00Z","level":"nope","caller":"nope"}`+"\n", string(jb))
+}
+
+func TestJSONReaderUnexpectedEOF(t *testing.T) {
+	r := NewJSONReader(strings.NewReader(`{"a":1,"b":`))
+
+	_, err := r.ReadEvent()
+	assert.Error(t, err)
+}
+
+func testWellKnown(t *testing.T, b []byte) {
+	t.Helper()
+
+	var d tlwire.Decoder
+
+	tag, els, i := d.Tag(b, 0)
+	require.Equal(t, tlwire.Map, tag)
+	require.Equal(t, int64(-1), els)
+
+	var keys []string
+
+	for !d.Break(b, &i) {
+		var k []byte
+		k, i = d.Bytes(b, i)
+
+		keys = append(keys, string(k))
+
+		switch string(k) {
+		case tlog.KeyTimestamp:
+			var ts time.Time
+			ts, i = d.Time(b, i)
+
+			assert.Equal(t, time.Date(2025, time.March, 1, 12, 0, 0, 5e8, time.UTC), ts.UTC())
+		case tlog.KeyLogLevel:
+			var lv tlog.LogLevel
+			i = lv.TlogParse(b, i)
+
+			assert.Equal(t, tlog.Warn, lv)
+		case tlog.KeyMessage:
+			assert.Equal(t, byte(tlwire.Semantic|tlog.WireMessage), b[i])
+
+			var m []byte
+			m, i = d.Bytes(b, i+1)
+
+			assert.Equal(t, "hello", string(m))
+		case tlog.KeyCaller:
+			pc, end := d.Caller(b, i)
+			i = end
+
+			_, file, line := pc.NameFileLine()
+			assert.Equal(t, "path/file.go", file)
+			assert.Equal(t, 10, line)
+		default:
+			i = d.Skip(b, i)
+		}
+	}
+
+	assert.Equal(t, []string{tlog.KeyTimestamp, tlog.KeyLogLevel, tlog.KeyMessage, tlog.KeyCaller, "a"}, keys)
+}
@@ -12,6 +12,7 @@ import (
 	"nikand.dev/go/hacked/low"
 
 	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlio"
 	"tlog.app/go/tlog/tlwire"
 )
 
@@ -180,3 +181,41 @@ func BenchmarkJSONPrintw(b *testing.B) {
 		l.Printw("message", "a", i+1000, "b", i+1000)
 	}
 }
+
+func TestJSONRepeated(t *testing.T) {
+	tm := time.Date(2020, time.December, 25, 22, 8, 13, 0, time.UTC)
+
+	var b low.Buf
+
+	j := NewJSON(&b)
+	j.TimeZone = time.UTC
+
+	w := tlio.NewDedup(j)
+
+	l := tlog.New(w)
+
+	tlog.LoggerSetTimeNow(l, func() time.Time { return tm }, func() int64 {
+		tm = tm.Add(time.Second)
+		return tm.UnixNano()
+	})
+
+	for range 4 {
+		l.Printw("reconnect", "err", "refused")
+	}
+
+	err := w.Close()
+	require.NoError(t, err)
+
+	exp := `{"_t":"2020-12-25T22:08:14Z","_c":"[\w./-]*json_test.go:\d+","_m":"reconnect","err":"refused"}
+{"_t":"2020-12-25T22:08:17Z","_tf":"2020-12-25T22:08:15Z","_r":3,"_c":"[\w./-]*json_test.go:\d+","_m":"reconnect","err":"refused"}
+`
+
+	ls := strings.SplitAfter(string(b), "\n")
+	exps := strings.SplitAfter(exp, "\n")
+
+	require.Equal(t, len(exps), len(ls), "%s", b)
+
+	for i, e := range exps {
+		assert.Regexp(t, "^"+e+"$", ls[i])
+	}
+}
@@ -0,0 +1,140 @@
+package convert
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// LogfmtReader reads logfmt lines and converts them into tlwire events.
+	// Well-known keys like time, msg, level, and caller are mapped to tlog keys.
+	// Unquoted values that look like numbers or booleans are encoded as such.
+	LogfmtReader struct {
+		eventReader
+
+		r *bufio.Reader
+	}
+)
+
+func NewLogfmtReader(r io.Reader) *LogfmtReader {
+	x := &LogfmtReader{
+		r: bufio.NewReader(r),
+	}
+
+	x.next = x.readEvent
+
+	return x
+}
+
+func (r *LogfmtReader) readEvent(b []byte) (_ []byte, err error) {
+	var line string
+
+	for line == "" {
+		line, err = r.r.ReadString('\n')
+		if errors.Is(err, io.EOF) && line != "" {
+			err = nil
+		}
+		if err != nil {
+			return b, err
+		}
+
+		line = strings.TrimSpace(line)
+	}
+
+	b = r.e.AppendMap(b, -1)
+
+	for line != "" {
+		var k, v string
+		var quoted bool
+
+		k, v, quoted, line, err = nextPair(line)
+		if err != nil {
+			return b, err
+		}
+
+		var ok bool
+
+		b, ok = r.appendWellKnown(b, k, v)
+		if ok {
+			continue
+		}
+
+		b = r.e.AppendString(b, k)
+		b = r.appendValue(b, v, quoted)
+	}
+
+	return r.e.AppendBreak(b), nil
+}
+
+func (r *LogfmtReader) appendValue(b []byte, v string, quoted bool) []byte {
+	if quoted {
+		return r.e.AppendString(b, v)
+	}
+
+	if x, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return r.e.AppendInt64(b, x)
+	}
+
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return r.e.AppendFloat(b, f)
+	}
+
+	switch v {
+	case "true":
+		return append(b, byte(tlwire.Special|tlwire.True))
+	case "false":
+		return append(b, byte(tlwire.Special|tlwire.False))
+	case "<nil>", "null":
+		return append(b, byte(tlwire.Special|tlwire.Nil))
+	}
+
+	return r.e.AppendString(b, v)
+}
+
+// nextPair parses the first k=v pair of the line.
+// Key without = is a key with an empty value.
+func nextPair(line string) (k, v string, quoted bool, rest string, err error) {
+	end := strings.IndexAny(line, "= ")
+	if end < 0 {
+		return line, "", false, "", nil
+	}
+
+	k, rest = line[:end], line[end:]
+
+	if k == "" {
+		return "", "", false, "", fmt.Errorf("empty key: %q", line)
+	}
+
+	if rest[0] == ' ' {
+		return k, "", false, strings.TrimLeft(rest, " "), nil
+	}
+
+	rest = rest[1:]
+
+	if rest != "" && rest[0] == '"' {
+		q, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return "", "", false, "", fmt.Errorf("key %v: %w", k, err)
+		}
+
+		v, err = strconv.Unquote(q)
+		if err != nil {
+			return "", "", false, "", fmt.Errorf("key %v: %w", k, err)
+		}
+
+		return k, v, true, strings.TrimLeft(rest[len(q):], " "), nil
+	}
+
+	end = strings.IndexByte(rest, ' ')
+	if end < 0 {
+		end = len(rest)
+	}
+
+	return k, rest[:end], false, strings.TrimLeft(rest[end:], " "), nil
+}
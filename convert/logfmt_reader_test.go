@@ -0,0 +1,60 @@
+package convert
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestLogfmtReader(t *testing.T) {
+	r := NewLogfmtReader(strings.NewReader(`time=2025-03-01T12:00:00.5Z level=warn msg=hello caller=path/file.go:10 a=1
+
+str="quoted \"value\""  int=-5 float=1.5  bool=true quoted_int="5" empty= flag
+`))
+
+	b, err := r.ReadEvent()
+	require.NoError(t, err)
+
+	testWellKnown(t, b)
+
+	b, err = r.ReadEvent()
+	require.NoError(t, err)
+
+	var e tlwire.Encoder
+	var exp low.Buf
+
+	exp = e.AppendMap(exp, -1)
+	exp = e.AppendString(exp, "str")
+	exp = e.AppendString(exp, `quoted "value"`)
+	exp = e.AppendString(exp, "int")
+	exp = e.AppendInt64(exp, -5)
+	exp = e.AppendString(exp, "float")
+	exp = e.AppendFloat(exp, 1.5)
+	exp = e.AppendString(exp, "bool")
+	exp = append(exp, byte(tlwire.Special|tlwire.True))
+	exp = e.AppendString(exp, "quoted_int")
+	exp = e.AppendString(exp, "5")
+	exp = e.AppendString(exp, "empty")
+	exp = e.AppendString(exp, "")
+	exp = e.AppendString(exp, "flag")
+	exp = e.AppendString(exp, "")
+	exp = e.AppendBreak(exp)
+
+	assert.Equal(t, exp, low.Buf(b))
+
+	_, err = r.ReadEvent()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestLogfmtReaderBadQuote(t *testing.T) {
+	r := NewLogfmtReader(strings.NewReader(`a="unterminated`))
+
+	_, err := r.ReadEvent()
+	assert.Error(t, err)
+}
@@ -0,0 +1,663 @@
+package convert
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nikand.dev/go/hacked/low"
+	"tlog.app/go/loc"
+
+	"tlog.app/go/tlog"
+	tlow "tlog.app/go/tlog/low"
+	"tlog.app/go/tlog/tlio"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// OTLP converts spans into OTLP/JSON trace export requests.
+	// A request with a single span is written as a line when the span finishes.
+	//
+	// Span start event kvs become span attributes, its labels become resource attributes.
+	// Events logged inside the span become span events.
+	// Events with Error or higher level and non-nil "err" finish kv set the span error status.
+	// Events outside of known spans are dropped.
+	//
+	// tlog has no trace id, so the root span id is used instead.
+	// If the parent span is not known (it may be in another service),
+	// the parent id is used as the trace id.
+	// OTLP span ids are the first halves of tlog ids,
+	// full ids are kept in tlog.span_id and tlog.parent_id attributes
+	// so OTLPReader restores them.
+	OTLP struct {
+		io.Writer
+
+		ScopeName string
+
+		d tlwire.Decoder
+
+		spans map[tlog.ID]*otlpSpan
+
+		attrs, ls low.Buf
+		b         low.Buf
+	}
+
+	otlpSpan struct {
+		trace, id, parent tlog.ID
+
+		name        []byte
+		start, last int64
+
+		res, attrs, events []byte
+
+		status    int
+		statusMsg []byte
+	}
+)
+
+// OTLP status codes.
+const (
+	otlpStatusUnset = iota
+	otlpStatusOK
+	otlpStatusError
+)
+
+const otlpSpanKindInternal = 1
+
+// Span attributes keeping full tlog ids. See otlpSpanID and tlogSpanID.
+const (
+	otlpAttrSpanID   = "tlog.span_id"
+	otlpAttrParentID = "tlog.parent_id"
+)
+
+func NewOTLP(w io.Writer) *OTLP {
+	return &OTLP{
+		Writer:    w,
+		ScopeName: "tlog",
+		spans:     make(map[tlog.ID]*otlpSpan),
+	}
+}
+
+func (w *OTLP) Write(p []byte) (i int, err error) {
+	for i < len(p) {
+		i, err = w.writeEvent(p, i)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *OTLP) writeEvent(p []byte, st int) (i int, err error) {
+	tag, els, i := w.d.Tag(p, st)
+	if tag != tlwire.Map {
+		return st, errors.New("map expected")
+	}
+
+	var s, par tlog.ID
+	var ts, elapsed int64
+	var ek tlog.EventKind
+	var lv tlog.LogLevel
+	var msg, errMsg []byte
+	var pc loc.PC
+	var isErr bool
+
+	w.attrs = w.attrs[:0]
+	w.ls = w.ls[:0]
+
+	var k []byte
+	var sub int64
+
+	for el := 0; els == -1 || el < int(els); el++ {
+		if els == -1 && w.d.Break(p, &i) {
+			break
+		}
+
+		k, i = w.d.Bytes(p, i)
+		if len(k) == 0 {
+			return st, errors.New("empty key")
+		}
+
+		vst := i
+
+		tag, sub, i = w.d.Tag(p, i)
+
+		switch {
+		case tag == tlwire.Semantic && sub == tlog.WireID && string(k) == tlog.KeySpan:
+			i = s.TlogParse(p, vst)
+		case tag == tlwire.Semantic && sub == tlog.WireID && string(k) == tlog.KeyParent:
+			i = par.TlogParse(p, vst)
+		case tag == tlwire.Semantic && sub == tlwire.Time && string(k) == tlog.KeyTimestamp:
+			ts, i = w.d.Timestamp(p, vst)
+		case tag == tlwire.Semantic && sub == tlwire.Duration && string(k) == tlog.KeyElapsed:
+			var d time.Duration
+			d, i = w.d.Duration(p, vst)
+			elapsed = d.Nanoseconds()
+		case tag == tlwire.Semantic && sub == tlwire.Caller && string(k) == tlog.KeyCaller && pc == 0:
+			pc, i = w.d.Caller(p, vst)
+		case tag == tlwire.Semantic && sub == tlog.WireEventKind && string(k) == tlog.KeyEventKind:
+			i = ek.TlogParse(p, vst)
+		case tag == tlwire.Semantic && sub == tlog.WireLogLevel && string(k) == tlog.KeyLogLevel:
+			i = lv.TlogParse(p, vst)
+		case tag == tlwire.Semantic && sub == tlog.WireMessage && string(k) == tlog.KeyMessage:
+			msg, i = w.d.Bytes(p, i)
+		case tag == tlwire.Semantic && sub == tlog.WireLabel:
+			w.ls, i = w.appendAttr(w.ls, p, k, i)
+		default:
+			if string(k) == "err" && !w.isNil(p, vst) {
+				isErr = true
+				errMsg = w.errorMessage(p, vst)
+			}
+
+			w.attrs, i = w.appendAttr(w.attrs, p, k, vst)
+		}
+	}
+
+	switch ek {
+	case tlog.EventSpanStart:
+		if s == (tlog.ID{}) {
+			break
+		}
+
+		sp := &otlpSpan{
+			id:     s,
+			parent: par,
+			trace:  s,
+			name:   append([]byte{}, msg...),
+			start:  ts,
+			last:   ts,
+			res:    append([]byte{}, w.ls...),
+		}
+
+		if ps, ok := w.spans[par]; ok {
+			sp.trace = ps.trace
+		} else if par != (tlog.ID{}) {
+			sp.trace = par
+		}
+
+		sp.attrs = w.appendStringAttr(sp.attrs, otlpAttrSpanID, s.StringFull())
+
+		if par != (tlog.ID{}) {
+			sp.attrs = w.appendStringAttr(sp.attrs, otlpAttrParentID, par.StringFull())
+		}
+
+		if pc != 0 {
+			name, file, line := pc.NameFileLine()
+
+			sp.attrs = w.appendStringAttr(sp.attrs, "code.function", name)
+			sp.attrs = w.appendStringAttr(sp.attrs, "code.filepath", file)
+			sp.attrs = w.appendIntAttr(sp.attrs, "code.lineno", int64(line))
+		}
+
+		sp.attrs = appendList(sp.attrs, w.attrs)
+
+		w.spans[s] = sp
+	case tlog.EventSpanFinish:
+		sp, ok := w.spans[s]
+		if !ok {
+			break
+		}
+
+		delete(w.spans, s)
+
+		sp.last = ts
+		if elapsed != 0 {
+			sp.last = sp.start + elapsed
+		}
+
+		sp.attrs = appendList(sp.attrs, w.attrs)
+
+		if isErr {
+			sp.status = otlpStatusError
+			sp.statusMsg = append(sp.statusMsg[:0], errMsg...)
+		}
+
+		err = w.writeSpan(sp)
+		if err != nil {
+			return st, err
+		}
+	default:
+		sp, ok := w.spans[s]
+		if !ok {
+			break
+		}
+
+		if ts > sp.last {
+			sp.last = ts
+		}
+
+		sp.events = w.appendSpanEvent(sp.events, ts, msg, lv)
+
+		if lv >= tlog.Error && sp.status != otlpStatusError {
+			sp.status = otlpStatusError
+			sp.statusMsg = append(sp.statusMsg[:0], msg...)
+		}
+	}
+
+	return i, nil
+}
+
+// Close writes spans which are not finished yet and closes the underlaying writer.
+// End time of such spans is the time of their last event.
+func (w *OTLP) Close() (err error) {
+	spans := make([]*otlpSpan, 0, len(w.spans))
+
+	for _, sp := range w.spans {
+		spans = append(spans, sp)
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	for _, sp := range spans {
+		delete(w.spans, sp.id)
+
+		e := w.writeSpan(sp)
+		if err == nil {
+			err = e
+		}
+	}
+
+	e := tlio.Close(w.Writer)
+	if err == nil {
+		err = e
+	}
+
+	return err
+}
+
+func (w *OTLP) writeSpan(sp *otlpSpan) error {
+	b := w.b[:0]
+
+	b = append(b, `{"resourceSpans":[{"resource":{"attributes":[`...)
+	b = append(b, sp.res...)
+	b = append(b, `]},"scopeSpans":[{"scope":{"name":"`...)
+	b = tlow.AppendSafe(b, []byte(w.ScopeName))
+	b = append(b, `"},"spans":[{"traceId":"`...)
+	b = hex.AppendEncode(b, sp.trace[:])
+	b = append(b, `","spanId":"`...)
+	b = hex.AppendEncode(b, otlpSpanID(sp.id))
+	b = append(b, '"')
+
+	if sp.parent != (tlog.ID{}) {
+		b = append(b, `,"parentSpanId":"`...)
+		b = hex.AppendEncode(b, otlpSpanID(sp.parent))
+		b = append(b, '"')
+	}
+
+	b = append(b, `,"name":"`...)
+	b = tlow.AppendSafe(b, sp.name)
+	b = append(b, `","kind":`...)
+	b = strconv.AppendInt(b, otlpSpanKindInternal, 10)
+
+	b = append(b, `,"startTimeUnixNano":"`...)
+	b = strconv.AppendInt(b, sp.start, 10)
+	b = append(b, `","endTimeUnixNano":"`...)
+	b = strconv.AppendInt(b, sp.last, 10)
+	b = append(b, `","attributes":[`...)
+	b = append(b, sp.attrs...)
+	b = append(b, `],"events":[`...)
+	b = append(b, sp.events...)
+	b = append(b, ']')
+
+	if sp.status != otlpStatusUnset {
+		b = append(b, `,"status":{"code":`...)
+		b = strconv.AppendInt(b, int64(sp.status), 10)
+
+		if len(sp.statusMsg) != 0 {
+			b = append(b, `,"message":"`...)
+			b = tlow.AppendSafe(b, sp.statusMsg)
+			b = append(b, '"')
+		}
+
+		b = append(b, '}')
+	}
+
+	b = append(b, "}]}]}]}\n"...)
+
+	w.b = b[:0]
+
+	_, err := w.Writer.Write(b)
+
+	return err
+}
+
+func (w *OTLP) appendSpanEvent(b []byte, ts int64, msg []byte, lv tlog.LogLevel) []byte {
+	if len(b) != 0 {
+		b = append(b, ',')
+	}
+
+	b = append(b, `{"timeUnixNano":"`...)
+	b = strconv.AppendInt(b, ts, 10)
+	b = append(b, `","name":"`...)
+	b = tlow.AppendSafe(b, msg)
+	b = append(b, `","attributes":[`...)
+
+	attrs := w.attrs
+
+	switch lv {
+	case tlog.Info:
+	case tlog.Warn:
+		attrs = w.appendStringAttr(attrs, "level", "warn")
+	case tlog.Error:
+		attrs = w.appendStringAttr(attrs, "level", "error")
+	case tlog.Fatal:
+		attrs = w.appendStringAttr(attrs, "level", "fatal")
+	case tlog.Debug:
+		attrs = w.appendStringAttr(attrs, "level", "debug")
+	default:
+		attrs = w.appendIntAttr(attrs, "level", int64(lv))
+	}
+
+	b = append(b, attrs...)
+	b = append(b, "]}"...)
+
+	return b
+}
+
+func (w *OTLP) appendAttr(b, p, k []byte, st int) (_ []byte, i int) {
+	if len(b) != 0 {
+		b = append(b, ',')
+	}
+
+	b = append(b, `{"key":"`...)
+	b = tlow.AppendSafe(b, k)
+	b = append(b, `","value":`...)
+
+	b, i = w.appendValue(b, p, st)
+
+	b = append(b, '}')
+
+	return b, i
+}
+
+func (w *OTLP) appendStringAttr(b []byte, k, v string) []byte {
+	if len(b) != 0 {
+		b = append(b, ',')
+	}
+
+	b = append(b, `{"key":"`...)
+	b = tlow.AppendSafe(b, []byte(k))
+	b = append(b, `","value":{"stringValue":"`...)
+	b = tlow.AppendSafe(b, []byte(v))
+	b = append(b, `"}}`...)
+
+	return b
+}
+
+func (w *OTLP) appendIntAttr(b []byte, k string, v int64) []byte {
+	if len(b) != 0 {
+		b = append(b, ',')
+	}
+
+	b = append(b, `{"key":"`...)
+	b = tlow.AppendSafe(b, []byte(k))
+	b = append(b, `","value":{"intValue":"`...)
+	b = strconv.AppendInt(b, v, 10)
+	b = append(b, `"}}`...)
+
+	return b
+}
+
+// appendValue appends tlwire value as OTLP AnyValue.
+func (w *OTLP) appendValue(b, p []byte, st int) (_ []byte, i int) {
+	tag, l, i := w.d.Tag(p, st)
+
+	switch tag {
+	case tlwire.Int:
+		b = append(b, `{"intValue":"`...)
+		b = strconv.AppendUint(b, uint64(l), 10)
+		b = append(b, `"}`...)
+	case tlwire.Neg:
+		b = append(b, `{"intValue":"`...)
+		b = strconv.AppendInt(b, -l-1, 10)
+		b = append(b, `"}`...)
+	case tlwire.Bytes:
+		b = append(b, `{"bytesValue":"`...)
+		b = base64.StdEncoding.AppendEncode(b, p[i:i+int(l)])
+		b = append(b, `"}`...)
+
+		i += int(l)
+	case tlwire.String:
+		b = append(b, `{"stringValue":"`...)
+		b = tlow.AppendSafe(b, p[i:i+int(l)])
+		b = append(b, `"}`...)
+
+		i += int(l)
+	case tlwire.Array:
+		b = append(b, `{"arrayValue":{"values":[`...)
+
+		for el := 0; l == -1 || el < int(l); el++ {
+			if l == -1 && w.d.Break(p, &i) {
+				break
+			}
+
+			if el != 0 {
+				b = append(b, ',')
+			}
+
+			b, i = w.appendValue(b, p, i)
+		}
+
+		b = append(b, `]}}`...)
+	case tlwire.Map:
+		b = append(b, `{"kvlistValue":{"values":[`...)
+
+		for el := 0; l == -1 || el < int(l); el++ {
+			if l == -1 && w.d.Break(p, &i) {
+				break
+			}
+
+			if el != 0 {
+				b = append(b, ',')
+			}
+
+			b = append(b, `{"key":`...)
+			b, i = w.appendKey(b, p, i)
+			b = append(b, `,"value":`...)
+			b, i = w.appendValue(b, p, i)
+			b = append(b, '}')
+		}
+
+		b = append(b, `]}}`...)
+	case tlwire.Semantic:
+		switch l {
+		case tlwire.Time:
+			var t time.Time
+			t, i = w.d.Time(p, st)
+
+			b = append(b, `{"stringValue":"`...)
+			b = t.UTC().AppendFormat(b, time.RFC3339Nano)
+			b = append(b, `"}`...)
+		case tlwire.Duration:
+			if sub := w.d.TagOnly(p, i); sub != tlwire.Int && sub != tlwire.Neg {
+				return w.appendValue(b, p, i)
+			}
+
+			var d time.Duration
+			d, i = w.d.Duration(p, st)
+
+			b = append(b, `{"stringValue":"`...)
+			b = append(b, d.String()...)
+			b = append(b, `"}`...)
+		case tlog.WireID:
+			var id tlog.ID
+			i = id.TlogParse(p, st)
+
+			b = append(b, `{"stringValue":"`...)
+			b = hex.AppendEncode(b, id[:])
+			b = append(b, `"}`...)
+		case tlwire.Caller:
+			var pc loc.PC
+			var pcs loc.PCs
+			pc, pcs, i = w.d.Callers(p, st)
+
+			if pcs == nil {
+				b = appendCallerValue(b, pc)
+				break
+			}
+
+			b = append(b, `{"arrayValue":{"values":[`...)
+
+			for j, pc := range pcs {
+				if j != 0 {
+					b = append(b, ',')
+				}
+
+				b = appendCallerValue(b, pc)
+			}
+
+			b = append(b, `]}}`...)
+		default:
+			b, i = w.appendValue(b, p, i)
+		}
+	case tlwire.Special:
+		switch l {
+		case tlwire.False:
+			b = append(b, `{"boolValue":false}`...)
+		case tlwire.True:
+			b = append(b, `{"boolValue":true}`...)
+		case tlwire.Float64, tlwire.Float32, tlwire.Float16, tlwire.Float8:
+			var f float64
+			f, i = w.d.Float(p, st)
+
+			b = append(b, `{"doubleValue":`...)
+
+			switch {
+			case math.IsNaN(f):
+				b = append(b, `"NaN"`...)
+			case math.IsInf(f, 1):
+				b = append(b, `"Infinity"`...)
+			case math.IsInf(f, -1):
+				b = append(b, `"-Infinity"`...)
+			default:
+				b = strconv.AppendFloat(b, f, 'g', -1, 64)
+			}
+
+			b = append(b, '}')
+		default:
+			b = append(b, `{}`...)
+		}
+	}
+
+	return b, i
+}
+
+func (w *OTLP) appendKey(b, p []byte, st int) (_ []byte, i int) {
+	tag, l, i := w.d.Tag(p, st)
+
+	switch tag {
+	case tlwire.String, tlwire.Bytes:
+		b = append(b, '"')
+		b = tlow.AppendSafe(b, p[i:i+int(l)])
+		b = append(b, '"')
+
+		return b, i + int(l)
+	case tlwire.Int:
+		b = append(b, '"')
+		b = strconv.AppendUint(b, uint64(l), 10)
+		b = append(b, '"')
+	case tlwire.Neg:
+		b = append(b, '"')
+		b = strconv.AppendInt(b, -l-1, 10)
+		b = append(b, '"')
+	default:
+		b = append(b, `""`...)
+		i = w.d.Skip(p, st)
+	}
+
+	return b, i
+}
+
+func (w *OTLP) isNil(p []byte, st int) bool {
+	tag, sub, i := w.d.Tag(p, st)
+
+	if tag == tlwire.Semantic {
+		return w.isNil(p, i)
+	}
+
+	return tag == tlwire.Special && (sub == tlwire.Nil || sub == tlwire.Undefined || sub == tlwire.None)
+}
+
+func (w *OTLP) errorMessage(p []byte, st int) []byte {
+	tag, _, i := w.d.Tag(p, st)
+
+	switch tag {
+	case tlwire.Semantic:
+		return w.errorMessage(p, i)
+	case tlwire.String, tlwire.Bytes:
+		m, _ := w.d.Bytes(p, st)
+		return m
+	}
+
+	return nil
+}
+
+func appendCallerValue(b []byte, pc loc.PC) []byte {
+	_, file, line := pc.NameFileLine()
+
+	b = append(b, `{"stringValue":"`...)
+	b = tlow.AppendSafe(b, []byte(file))
+	b = append(b, ':')
+	b = strconv.AppendInt(b, int64(line), 10)
+	b = append(b, `"}`...)
+
+	return b
+}
+
+func appendList(b, l []byte) []byte {
+	if len(b) != 0 && len(l) != 0 {
+		b = append(b, ',')
+	}
+
+	return append(b, l...)
+}
+
+// otlpSpanID returns OTLP span id for tlog.ID.
+func otlpSpanID(id tlog.ID) []byte {
+	return id[:8]
+}
+
+// tlogSpanID returns tlog.ID for OTLP trace and span ids.
+// full is the otlpAttrSpanID or otlpAttrParentID attribute value, it's used if it matches span.
+// Otherwise span id and the second half of trace id are merged,
+// so spans of a trace are still linked by KeySpan and KeyParent.
+func tlogSpanID(trace, span, full string) (id tlog.ID, err error) {
+	if full != "" {
+		id, err = tlog.IDFromString(full)
+		if err == nil && strings.EqualFold(hex.EncodeToString(otlpSpanID(id)), span) {
+			return id, nil
+		}
+
+		id = tlog.ID{}
+	}
+
+	s, err := hex.DecodeString(span)
+	if err != nil || len(s) != 8 {
+		return id, fmt.Errorf("bad span id: %q", span)
+	}
+
+	copy(id[:8], s)
+
+	if trace == "" {
+		return id, nil
+	}
+
+	t, err := hex.DecodeString(trace)
+	if err != nil || len(t) != 16 {
+		return id, fmt.Errorf("bad trace id: %q", trace)
+	}
+
+	copy(id[8:], t[8:])
+
+	return id, nil
+}
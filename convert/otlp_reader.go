@@ -0,0 +1,500 @@
+package convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// OTLPReader reads a stream of OTLP/JSON export requests and converts them into tlwire events.
+	// Both trace and log requests are supported, a request may contain both of them.
+	//
+	// Each span becomes a span start event, its span events, and a span finish event.
+	// Full tlog ids are restored from the attributes written by OTLP.
+	// Other span and trace ids are merged into tlog.ID: the first half is the span id,
+	// the second half is the second half of the trace id.
+	// So spans of a trace are linked by KeySpan and KeyParent as usual.
+	// Log records severity becomes KeyLogLevel, string body becomes KeyMessage.
+	// Resource attributes are added to every event as labels.
+	OTLPReader struct {
+		eventReader
+
+		d *json.Decoder
+
+		q []byte // decoded request events
+		i int
+	}
+
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+		ResourceLogs  []otlpResourceLogs  `json:"resourceLogs"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource `json:"resource"`
+		ScopeSpans []struct {
+			Spans []otlpSpanData `json:"spans"`
+		} `json:"scopeSpans"`
+	}
+
+	otlpResourceLogs struct {
+		Resource  otlpResource `json:"resource"`
+		ScopeLogs []struct {
+			LogRecords []otlpLogRecord `json:"logRecords"`
+		} `json:"scopeLogs"`
+	}
+
+	otlpSpanData struct {
+		TraceID      string         `json:"traceId"`
+		SpanID       string         `json:"spanId"`
+		ParentSpanID string         `json:"parentSpanId"`
+		Name         string         `json:"name"`
+		Start        otlpInt        `json:"startTimeUnixNano"`
+		End          otlpInt        `json:"endTimeUnixNano"`
+		Attributes   []otlpKeyValue `json:"attributes"`
+		Events       []struct {
+			Time       otlpInt        `json:"timeUnixNano"`
+			Name       string         `json:"name"`
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"events"`
+		Status struct {
+			Code    otlpEnum `json:"code"`
+			Message string   `json:"message"`
+		} `json:"status"`
+	}
+
+	otlpLogRecord struct {
+		Time           otlpInt        `json:"timeUnixNano"`
+		ObservedTime   otlpInt        `json:"observedTimeUnixNano"`
+		SeverityNumber otlpEnum       `json:"severityNumber"`
+		SeverityText   string         `json:"severityText"`
+		Body           *otlpAnyValue  `json:"body"`
+		Attributes     []otlpKeyValue `json:"attributes"`
+		TraceID        string         `json:"traceId"`
+		SpanID         string         `json:"spanId"`
+	}
+
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+
+	otlpAnyValue struct {
+		StringValue *string    `json:"stringValue"`
+		BoolValue   *bool      `json:"boolValue"`
+		IntValue    *otlpInt   `json:"intValue"`
+		DoubleValue *otlpFloat `json:"doubleValue"`
+		BytesValue  []byte     `json:"bytesValue"`
+		ArrayValue  *struct {
+			Values []otlpAnyValue `json:"values"`
+		} `json:"arrayValue"`
+		KvlistValue *struct {
+			Values []otlpKeyValue `json:"values"`
+		} `json:"kvlistValue"`
+	}
+
+	// otlpInt is int64 encoded as a JSON string or a number.
+	otlpInt int64
+
+	// otlpFloat is float64 which can be "NaN", "Infinity", or "-Infinity".
+	otlpFloat float64
+
+	// otlpEnum is protobuf enum encoded as a number or a name.
+	otlpEnum int
+)
+
+var otlpEnumNames = map[string]otlpEnum{
+	"STATUS_CODE_UNSET": otlpStatusUnset,
+	"STATUS_CODE_OK":    otlpStatusOK,
+	"STATUS_CODE_ERROR": otlpStatusError,
+}
+
+func init() {
+	for i, name := range []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"} {
+		for j := range 4 {
+			n := "SEVERITY_NUMBER_" + name
+			if j != 0 {
+				n += strconv.Itoa(j + 1)
+			}
+
+			otlpEnumNames[n] = otlpEnum(1 + 4*i + j)
+		}
+	}
+}
+
+func NewOTLPReader(r io.Reader) *OTLPReader {
+	x := &OTLPReader{
+		d: json.NewDecoder(r),
+	}
+
+	x.next = x.readEvent
+
+	return x
+}
+
+func (r *OTLPReader) readEvent(b []byte) (_ []byte, err error) {
+	for r.i == len(r.q) {
+		var req otlpRequest
+
+		err = r.d.Decode(&req)
+		if err != nil {
+			return b, err
+		}
+
+		r.q, err = r.appendRequest(r.q[:0], &req)
+		r.i = 0
+
+		if err != nil {
+			r.q = r.q[:0]
+			return b, err
+		}
+	}
+
+	var d tlwire.Decoder
+
+	st := r.i
+	r.i = d.Skip(r.q, st)
+
+	return append(b, r.q[st:r.i]...), nil
+}
+
+func (r *OTLPReader) appendRequest(b []byte, req *otlpRequest) (_ []byte, err error) {
+	var ls []byte
+
+	for _, rs := range req.ResourceSpans {
+		ls = r.appendLabels(ls[:0], rs.Resource.Attributes)
+
+		for _, ss := range rs.ScopeSpans {
+			for _, sp := range ss.Spans {
+				b, err = r.appendSpan(b, &sp, ls)
+				if err != nil {
+					return b, fmt.Errorf("span %v: %w", sp.SpanID, err)
+				}
+			}
+		}
+	}
+
+	for _, rl := range req.ResourceLogs {
+		ls = r.appendLabels(ls[:0], rl.Resource.Attributes)
+
+		for _, sl := range rl.ScopeLogs {
+			for _, rec := range sl.LogRecords {
+				b, err = r.appendLog(b, &rec, ls)
+				if err != nil {
+					return b, fmt.Errorf("log record: %w", err)
+				}
+			}
+		}
+	}
+
+	return b, nil
+}
+
+func (r *OTLPReader) appendSpan(b []byte, sp *otlpSpanData, ls []byte) (_ []byte, err error) {
+	id, err := tlogSpanID(sp.TraceID, sp.SpanID, otlpAttrString(sp.Attributes, otlpAttrSpanID))
+	if err != nil {
+		return b, err
+	}
+
+	if id == (tlog.ID{}) {
+		return b, errors.New("empty span id")
+	}
+
+	var par tlog.ID
+
+	if sp.ParentSpanID != "" {
+		par, err = tlogSpanID(sp.TraceID, sp.ParentSpanID, otlpAttrString(sp.Attributes, otlpAttrParentID))
+		if err != nil {
+			return b, fmt.Errorf("parent: %w", err)
+		}
+	}
+
+	b = r.e.AppendMap(b, -1)
+	b = r.appendID(b, tlog.KeySpan, id)
+	b = r.appendTimestamp(b, int64(sp.Start))
+	b = r.appendCodeCaller(b, sp.Attributes)
+	b = r.e.AppendString(b, tlog.KeyEventKind)
+	b = tlog.EventSpanStart.TlogAppend(b)
+
+	if par != (tlog.ID{}) {
+		b = r.appendID(b, tlog.KeyParent, par)
+	}
+
+	b = r.appendMessage(b, sp.Name)
+	b = r.appendAttrs(b, sp.Attributes)
+	b = append(b, ls...)
+	b = r.e.AppendBreak(b)
+
+	for _, ev := range sp.Events {
+		b = r.e.AppendMap(b, -1)
+		b = r.appendID(b, tlog.KeySpan, id)
+		b = r.appendTimestamp(b, int64(ev.Time))
+		b = r.appendMessage(b, ev.Name)
+		b = r.appendAttrs(b, ev.Attributes)
+		b = append(b, ls...)
+		b = r.e.AppendBreak(b)
+	}
+
+	b = r.e.AppendMap(b, -1)
+	b = r.appendID(b, tlog.KeySpan, id)
+	b = r.appendTimestamp(b, int64(sp.End))
+	b = r.e.AppendString(b, tlog.KeyEventKind)
+	b = tlog.EventSpanFinish.TlogAppend(b)
+
+	if sp.Start != 0 && sp.End != 0 {
+		b = r.e.AppendString(b, tlog.KeyElapsed)
+		b = r.e.AppendDuration(b, time.Duration(sp.End-sp.Start))
+	}
+
+	if sp.Status.Code == otlpStatusError {
+		msg := sp.Status.Message
+		if msg == "" {
+			msg = "error"
+		}
+
+		b = r.e.AppendString(b, "err")
+		b = r.e.AppendSemantic(b, tlwire.Error)
+		b = r.e.AppendString(b, msg)
+	}
+
+	b = append(b, ls...)
+	b = r.e.AppendBreak(b)
+
+	return b, nil
+}
+
+func (r *OTLPReader) appendLog(b []byte, rec *otlpLogRecord, ls []byte) (_ []byte, err error) {
+	var id tlog.ID
+
+	if rec.SpanID != "" {
+		id, err = tlogSpanID(rec.TraceID, rec.SpanID, otlpAttrString(rec.Attributes, otlpAttrSpanID))
+		if err != nil {
+			return b, err
+		}
+	}
+
+	ts := rec.Time
+	if ts == 0 {
+		ts = rec.ObservedTime
+	}
+
+	b = r.e.AppendMap(b, -1)
+
+	if id != (tlog.ID{}) {
+		b = r.appendID(b, tlog.KeySpan, id)
+	}
+
+	if ts != 0 {
+		b = r.appendTimestamp(b, int64(ts))
+	}
+
+	b = r.appendCodeCaller(b, rec.Attributes)
+
+	if rec.Body != nil && rec.Body.StringValue != nil {
+		b = r.appendMessage(b, *rec.Body.StringValue)
+	}
+
+	lv, ok := otlpLogLevel(rec.SeverityNumber)
+	if !ok {
+		lv, ok = parseLogLevel(rec.SeverityText)
+	}
+
+	if ok && lv != tlog.Info {
+		b = r.e.AppendString(b, tlog.KeyLogLevel)
+		b = lv.TlogAppend(b)
+	}
+
+	if rec.Body != nil && rec.Body.StringValue == nil {
+		b = r.e.AppendString(b, "body")
+		b = r.appendValue(b, rec.Body)
+	}
+
+	b = r.appendAttrs(b, rec.Attributes)
+	b = append(b, ls...)
+	b = r.e.AppendBreak(b)
+
+	return b, nil
+}
+
+func (r *OTLPReader) appendID(b []byte, k string, id tlog.ID) []byte {
+	b = r.e.AppendString(b, k)
+	return id.TlogAppend(b)
+}
+
+func (r *OTLPReader) appendTimestamp(b []byte, ts int64) []byte {
+	b = r.e.AppendString(b, tlog.KeyTimestamp)
+	return r.e.AppendTimestamp(b, ts)
+}
+
+func (r *OTLPReader) appendMessage(b []byte, m string) []byte {
+	if m == "" {
+		return b
+	}
+
+	b = r.e.AppendString(b, tlog.KeyMessage)
+	b = r.e.AppendSemantic(b, tlog.WireMessage)
+
+	return r.e.AppendString(b, m)
+}
+
+// appendCodeCaller encodes code.filepath and code.lineno attributes as KeyCaller.
+func (r *OTLPReader) appendCodeCaller(b []byte, attrs []otlpKeyValue) []byte {
+	var file *string
+	var line *otlpInt
+
+	for _, kv := range attrs {
+		switch kv.Key {
+		case "code.filepath":
+			file = kv.Value.StringValue
+		case "code.lineno":
+			line = kv.Value.IntValue
+		}
+	}
+
+	if file == nil || line == nil {
+		return b
+	}
+
+	b = r.e.AppendString(b, tlog.KeyCaller)
+
+	return r.appendCaller(b, *file+":"+strconv.FormatInt(int64(*line), 10), *file, int(*line))
+}
+
+func (r *OTLPReader) appendAttrs(b []byte, attrs []otlpKeyValue) []byte {
+	for _, kv := range attrs {
+		switch kv.Key {
+		case "code.filepath", "code.lineno", "code.function", otlpAttrSpanID, otlpAttrParentID:
+			continue
+		}
+
+		b = r.e.AppendString(b, kv.Key)
+		b = r.appendValue(b, &kv.Value)
+	}
+
+	return b
+}
+
+func otlpAttrString(attrs []otlpKeyValue, k string) string {
+	for _, kv := range attrs {
+		if kv.Key == k && kv.Value.StringValue != nil {
+			return *kv.Value.StringValue
+		}
+	}
+
+	return ""
+}
+
+func (r *OTLPReader) appendLabels(b []byte, attrs []otlpKeyValue) []byte {
+	for _, kv := range attrs {
+		b = r.e.AppendString(b, kv.Key)
+		b = r.e.AppendSemantic(b, tlog.WireLabel)
+		b = r.appendValue(b, &kv.Value)
+	}
+
+	return b
+}
+
+func (r *OTLPReader) appendValue(b []byte, v *otlpAnyValue) []byte {
+	switch {
+	case v.StringValue != nil:
+		return r.e.AppendString(b, *v.StringValue)
+	case v.BoolValue != nil && *v.BoolValue:
+		return append(b, byte(tlwire.Special|tlwire.True))
+	case v.BoolValue != nil:
+		return append(b, byte(tlwire.Special|tlwire.False))
+	case v.IntValue != nil:
+		return r.e.AppendInt64(b, int64(*v.IntValue))
+	case v.DoubleValue != nil:
+		return r.e.AppendFloat(b, float64(*v.DoubleValue))
+	case v.BytesValue != nil:
+		return r.e.AppendBytes(b, v.BytesValue)
+	case v.ArrayValue != nil:
+		b = r.e.AppendArray(b, len(v.ArrayValue.Values))
+
+		for i := range v.ArrayValue.Values {
+			b = r.appendValue(b, &v.ArrayValue.Values[i])
+		}
+
+		return b
+	case v.KvlistValue != nil:
+		b = r.e.AppendMap(b, len(v.KvlistValue.Values))
+
+		for i, kv := range v.KvlistValue.Values {
+			b = r.e.AppendString(b, kv.Key)
+			b = r.appendValue(b, &v.KvlistValue.Values[i].Value)
+		}
+
+		return b
+	}
+
+	return append(b, byte(tlwire.Special|tlwire.Nil))
+}
+
+// otlpLogLevel converts OTLP severity number.
+// TRACE and DEBUG become tlog.Debug.
+func otlpLogLevel(sev otlpEnum) (tlog.LogLevel, bool) {
+	switch {
+	case sev <= 0:
+		return 0, false
+	case sev <= 8:
+		return tlog.Debug, true
+	case sev <= 12:
+		return tlog.Info, true
+	case sev <= 16:
+		return tlog.Warn, true
+	case sev <= 20:
+		return tlog.Error, true
+	default:
+		return tlog.Fatal, true
+	}
+}
+
+func (x *otlpInt) UnmarshalJSON(p []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(p), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*x = otlpInt(v)
+
+	return nil
+}
+
+func (x *otlpFloat) UnmarshalJSON(p []byte) error {
+	v, err := strconv.ParseFloat(strings.Trim(string(p), `"`), 64)
+	if err != nil {
+		return err
+	}
+
+	*x = otlpFloat(v)
+
+	return nil
+}
+
+func (x *otlpEnum) UnmarshalJSON(p []byte) error {
+	if len(p) != 0 && p[0] == '"' {
+		*x = otlpEnumNames[strings.Trim(string(p), `"`)]
+		return nil
+	}
+
+	v, err := strconv.Atoi(string(p))
+	if err != nil {
+		return err
+	}
+
+	*x = otlpEnum(v)
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+)
+
+func TestOTLPReader(t *testing.T) {
+	r := NewOTLPReader(strings.NewReader(`{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+"scopeSpans":[{"scope":{"name":"otel"},"spans":[
+{"traceId":"0102030405060708090a0b0c0d0e0f10","spanId":"1112131415161718","name":"request","kind":2,
+"startTimeUnixNano":"1740830400000000000","endTimeUnixNano":"1740830400005000000",
+"attributes":[{"key":"code","value":{"intValue":"200"}},{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"},{"boolValue":true}]}}}],
+"events":[{"timeUnixNano":"1740830400001000000","name":"cache miss","attributes":[{"key":"ratio","value":{"doubleValue":0.5}}]}],
+"status":{"code":"STATUS_CODE_ERROR","message":"boom"}},
+{"traceId":"0102030405060708090a0b0c0d0e0f10","spanId":"2122232425262728","parentSpanId":"1112131415161718","name":"db",
+"startTimeUnixNano":"1740830400002000000","endTimeUnixNano":"1740830400003000000"}]}]}]}
+{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"worker"}}]},
+"scopeLogs":[{"logRecords":[
+{"timeUnixNano":"1740830400004000000","severityNumber":17,"body":{"stringValue":"failed"},"traceId":"0102030405060708090a0b0c0d0e0f10","spanId":"1112131415161718"},
+{"observedTimeUnixNano":"1740830400006000000","severityText":"warn","body":{"kvlistValue":{"values":[{"key":"k","value":{"intValue":3}}]}}}]}]}]}
+`))
+
+	var b low.Buf
+
+	w := NewJSON(&b)
+	w.TimeZone = time.UTC
+
+	_, err := r.WriteTo(w)
+	require.NoError(t, err)
+
+	const root = "11121314-1516-1718-090a-0b0c0d0e0f10"
+	const db = "21222324-2526-2728-090a-0b0c0d0e0f10"
+
+	exp := []string{
+		`{"_s":"` + root + `","_t":"2025-03-01T12:00:00Z","_k":"s","_m":"request","code":200,"tags":["a",true],"service.name":"api"}`,
+		`{"_s":"` + root + `","_t":"2025-03-01T12:00:00.001Z","_m":"cache miss","ratio":0.5,"service.name":"api"}`,
+		`{"_s":"` + root + `","_t":"2025-03-01T12:00:00.005Z","_k":"f","_e":5000000,"err":"boom","service.name":"api"}`,
+		`{"_s":"` + db + `","_t":"2025-03-01T12:00:00.002Z","_k":"s","_p":"` + root + `","_m":"db","service.name":"api"}`,
+		`{"_s":"` + db + `","_t":"2025-03-01T12:00:00.003Z","_k":"f","_e":1000000,"service.name":"api"}`,
+		`{"_s":"` + root + `","_t":"2025-03-01T12:00:00.004Z","_m":"failed","_l":2,"service.name":"worker"}`,
+		`{"_t":"2025-03-01T12:00:00.006Z","_l":1,"body":{"k":3},"service.name":"worker"}`,
+	}
+
+	assert.Equal(t, strings.Join(exp, "\n")+"\n", string(b))
+}
+
+func TestOTLPRoundTrip(t *testing.T) {
+	var b low.Buf
+
+	w := NewOTLP(&b)
+
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	l := tlog.New(w)
+	tlog.LoggerSetTimeNow(l, func() time.Time { return now }, func() int64 { return now.UnixNano() })
+	l.SetLabels("service", "api")
+
+	root := l.Start("request")
+
+	now = now.Add(time.Millisecond)
+	child := root.Spawn("db", "rows", 3)
+	grand := child.Spawn("conn")
+
+	now = now.Add(time.Millisecond)
+	grand.Finish()
+	child.Finish()
+	root.Finish()
+
+	var out low.Buf
+
+	_, err := NewOTLPReader(bytes.NewReader(b)).WriteTo(NewJSON(&out))
+	require.NoError(t, err)
+
+	type event struct {
+		S       tlog.ID `json:"_s"`
+		P       tlog.ID `json:"_p"`
+		K       string  `json:"_k"`
+		M       string  `json:"_m"`
+		C       string  `json:"_c"`
+		E       int64   `json:"_e"`
+		Rows    int     `json:"rows"`
+		Service string  `json:"service"`
+	}
+
+	var evs []event
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var ev event
+
+		err = json.Unmarshal([]byte(line), &ev)
+		require.NoError(t, err, "%s", line)
+
+		evs = append(evs, ev)
+	}
+
+	require.Equal(t, 6, len(evs))
+
+	assert.Equal(t, event{S: grand.ID, K: "s", M: "conn", P: child.ID, C: evs[0].C, Service: "api"}, evs[0])
+	assert.Equal(t, event{S: grand.ID, K: "f", E: 1e6, Service: "api"}, evs[1])
+	assert.Equal(t, event{S: child.ID, K: "s", M: "db", P: root.ID, C: evs[2].C, Rows: 3, Service: "api"}, evs[2])
+	assert.Equal(t, event{S: child.ID, K: "f", E: 1e6, Service: "api"}, evs[3])
+	assert.Equal(t, event{S: root.ID, K: "s", M: "request", C: evs[4].C, Service: "api"}, evs[4])
+	assert.Equal(t, event{S: root.ID, K: "f", E: 2e6, Service: "api"}, evs[5])
+
+	assert.Contains(t, evs[2].C, "otlp_reader_test.go:")
+}
@@ -0,0 +1,176 @@
+package convert
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+)
+
+type (
+	otlpTestKV struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+
+	otlpTestSpan struct {
+		TraceID      string       `json:"traceId"`
+		SpanID       string       `json:"spanId"`
+		ParentSpanID string       `json:"parentSpanId"`
+		Name         string       `json:"name"`
+		Start        string       `json:"startTimeUnixNano"`
+		End          string       `json:"endTimeUnixNano"`
+		Attributes   []otlpTestKV `json:"attributes"`
+		Events       []struct {
+			Time       string       `json:"timeUnixNano"`
+			Name       string       `json:"name"`
+			Attributes []otlpTestKV `json:"attributes"`
+		} `json:"events"`
+		Status *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+
+	otlpTestRequest struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpTestKV `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Spans []otlpTestSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+)
+
+func TestOTLP(t *testing.T) {
+	var b low.Buf
+
+	w := NewOTLP(&b)
+
+	base := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	now := base
+
+	l := tlog.New(w)
+	tlog.LoggerSetTimeNow(l, func() time.Time { return now }, func() int64 { return now.UnixNano() })
+	l.SetLabels("service", "api")
+
+	root := l.Start("request", "path", "/users")
+
+	now = base.Add(time.Millisecond)
+	child := root.Spawn("db")
+
+	now = base.Add(2 * time.Millisecond)
+	child.Printw("query", "rows", 3, "ratio", 0.5)
+
+	now = base.Add(3 * time.Millisecond)
+	child.Printw("retry failed", "", tlog.Error)
+	child.Finish()
+
+	l.Printw("outside of span")
+
+	now = base.Add(5 * time.Millisecond)
+	root.Finish("err", errors.New("not found"))
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Equal(t, 2, len(lines))
+
+	parse := func(line string) (res []otlpTestKV, sp otlpTestSpan) {
+		var r otlpTestRequest
+
+		err := json.Unmarshal([]byte(line), &r)
+		require.NoError(t, err, "%s", line)
+
+		require.Len(t, r.ResourceSpans, 1)
+		require.Len(t, r.ResourceSpans[0].ScopeSpans, 1)
+		assert.Equal(t, "tlog", r.ResourceSpans[0].ScopeSpans[0].Scope.Name)
+		require.Len(t, r.ResourceSpans[0].ScopeSpans[0].Spans, 1)
+
+		return r.ResourceSpans[0].Resource.Attributes, r.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	}
+
+	res, db := parse(lines[0])
+	assert.Equal(t, []otlpTestKV{{Key: "service", Value: map[string]any{"stringValue": "api"}}}, res)
+
+	assert.Equal(t, "db", db.Name)
+	assert.Equal(t, hex.EncodeToString(root.ID[:]), db.TraceID)
+	assert.Equal(t, hex.EncodeToString(child.ID[:8]), db.SpanID)
+	assert.Equal(t, hex.EncodeToString(root.ID[:8]), db.ParentSpanID)
+	assert.Equal(t, "1740830400001000000", db.Start)
+	assert.Equal(t, "1740830400003000000", db.End)
+
+	require.Len(t, db.Events, 2)
+	assert.Equal(t, "query", db.Events[0].Name)
+	assert.Equal(t, "1740830400002000000", db.Events[0].Time)
+	assert.Equal(t, []otlpTestKV{
+		{Key: "rows", Value: map[string]any{"intValue": "3"}},
+		{Key: "ratio", Value: map[string]any{"doubleValue": 0.5}},
+	}, db.Events[0].Attributes)
+	assert.Equal(t, []otlpTestKV{{Key: "level", Value: map[string]any{"stringValue": "error"}}}, db.Events[1].Attributes)
+
+	require.NotNil(t, db.Status)
+	assert.Equal(t, 2, db.Status.Code)
+	assert.Equal(t, "retry failed", db.Status.Message)
+
+	_, req := parse(lines[1])
+
+	assert.Equal(t, "request", req.Name)
+	assert.Equal(t, hex.EncodeToString(root.ID[:]), req.TraceID)
+	assert.Equal(t, "", req.ParentSpanID)
+	assert.Equal(t, "1740830400000000000", req.Start)
+	assert.Equal(t, "1740830400005000000", req.End)
+	assert.Empty(t, req.Events)
+
+	keys := map[string]map[string]any{}
+	for _, a := range req.Attributes {
+		keys[a.Key] = a.Value
+	}
+
+	assert.Equal(t, map[string]any{"stringValue": "/users"}, keys["path"])
+	assert.Equal(t, map[string]any{"stringValue": "not found"}, keys["err"])
+	assert.Contains(t, keys, "code.function")
+	assert.Contains(t, keys, "code.filepath")
+	assert.Contains(t, keys, "code.lineno")
+
+	require.NotNil(t, req.Status)
+	assert.Equal(t, 2, req.Status.Code)
+	assert.Equal(t, "not found", req.Status.Message)
+}
+
+func TestOTLPClose(t *testing.T) {
+	var b low.Buf
+
+	w := NewOTLP(&b)
+
+	l := tlog.New(w)
+
+	tr := l.Start("unfinished")
+	tr.Printw("message")
+
+	assert.Equal(t, 0, len(b))
+
+	err := w.Close()
+	require.NoError(t, err)
+
+	var r otlpTestRequest
+
+	err = json.Unmarshal(b, &r)
+	require.NoError(t, err, "%s", b)
+
+	sp := r.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "unfinished", sp.Name)
+	assert.Len(t, sp.Events, 1)
+	assert.Nil(t, sp.Status)
+}
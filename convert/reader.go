@@ -0,0 +1,214 @@
+package convert
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"tlog.app/go/loc"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// eventReader implements io.Reader and io.WriterTo on top of event by event parser.
+	eventReader struct {
+		e tlwire.Encoder
+
+		next func(b []byte) ([]byte, error)
+
+		b []byte
+		i int
+	}
+)
+
+// ReadEvent returns the next event.
+// The result is valid until the next call.
+func (r *eventReader) ReadEvent() (_ []byte, err error) {
+	r.b, err = r.next(r.b[:0])
+	r.i = len(r.b)
+
+	if err != nil {
+		r.b = r.b[:0]
+		r.i = 0
+
+		return nil, err
+	}
+
+	return r.b, nil
+}
+
+func (r *eventReader) Read(p []byte) (n int, err error) {
+	if r.i == len(r.b) {
+		_, err = r.ReadEvent()
+		if err != nil {
+			return 0, err
+		}
+
+		r.i = 0
+	}
+
+	n = copy(p, r.b[r.i:])
+	r.i += n
+
+	return n, nil
+}
+
+// WriteTo writes events to w one event per Write.
+func (r *eventReader) WriteTo(w io.Writer) (n int64, err error) {
+	for {
+		p, err := r.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		m, err := w.Write(p)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// appendWellKnown encodes well-known keys the way tlog.Logger does.
+// It returns false if the key is not known or the value can't be parsed.
+func (r *eventReader) appendWellKnown(b []byte, k, v string) ([]byte, bool) {
+	switch k {
+	case tlog.KeyTimestamp, "time", "ts", "timestamp":
+		ts, ok := parseTimestamp(v)
+		if !ok {
+			return b, false
+		}
+
+		b = r.e.AppendString(b, tlog.KeyTimestamp)
+		b = r.e.AppendTimestamp(b, ts)
+	case tlog.KeyMessage, "msg", "message":
+		b = r.e.AppendString(b, tlog.KeyMessage)
+		b = r.e.AppendSemantic(b, tlog.WireMessage)
+		b = r.e.AppendString(b, v)
+	case tlog.KeyLogLevel, "level", "lvl", "severity":
+		lv, ok := parseLogLevel(v)
+		if !ok {
+			return b, false
+		}
+
+		b = r.e.AppendString(b, tlog.KeyLogLevel)
+		b = lv.TlogAppend(b)
+	case tlog.KeyCaller, "caller":
+		file, line, ok := parseCaller(v)
+		if !ok {
+			return b, false
+		}
+
+		b = r.e.AppendString(b, tlog.KeyCaller)
+		b = r.appendCaller(b, v, file, line)
+	default:
+		return b, false
+	}
+
+	return b, true
+}
+
+// appendCaller encodes caller as tlwire.Encoder.AppendCaller does.
+// Original PC is unknown, so a fake one is derived from the location.
+// Decoder puts it into the loc cache, so it's formatted as usual.
+func (r *eventReader) appendCaller(b []byte, v, file string, line int) []byte {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(v))
+
+	pc := loc.PC(h.Sum64()) | 1<<(bits.UintSize-1)
+
+	b = append(b, byte(tlwire.Semantic|tlwire.Caller))
+	b = r.e.AppendMap(b, 4)
+
+	b = r.e.AppendString(b, "p")
+	b = r.e.AppendUint64(b, uint64(pc))
+
+	b = r.e.AppendString(b, "n")
+	b = r.e.AppendString(b, "")
+
+	b = r.e.AppendString(b, "f")
+	b = r.e.AppendString(b, file)
+
+	b = r.e.AppendString(b, "l")
+	b = r.e.AppendInt(b, line)
+
+	return b
+}
+
+// parseTimestamp parses RFC 3339 time or unix time number.
+// Number units are guessed by magnitude: seconds, milliseconds, microseconds, or nanoseconds.
+func parseTimestamp(v string) (int64, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t.UnixNano(), true
+	}
+
+	if x, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return x * unixUnit(math.Abs(float64(x))), true
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+
+	return int64(f * float64(unixUnit(math.Abs(f)))), true
+}
+
+func unixUnit(a float64) int64 {
+	switch {
+	case a >= 1e17:
+		return 1
+	case a >= 1e14:
+		return 1e3
+	case a >= 1e11:
+		return 1e6
+	default:
+		return 1e9
+	}
+}
+
+func parseLogLevel(v string) (tlog.LogLevel, bool) {
+	if x, err := strconv.Atoi(v); err == nil {
+		return tlog.LogLevel(x), true
+	}
+
+	switch strings.ToLower(v) {
+	case "debug", "dbg", "trace", "d":
+		return tlog.Debug, true
+	case "info", "inf", "i":
+		return tlog.Info, true
+	case "warn", "warning", "wrn", "w":
+		return tlog.Warn, true
+	case "error", "err", "erro", "e":
+		return tlog.Error, true
+	case "fatal", "panic", "crit", "critical", "f":
+		return tlog.Fatal, true
+	}
+
+	return 0, false
+}
+
+// parseCaller parses file:line.
+func parseCaller(v string) (file string, line int, ok bool) {
+	p := strings.LastIndexByte(v, ':')
+	if p <= 0 {
+		return "", 0, false
+	}
+
+	line, err := strconv.Atoi(v[p+1:])
+	if err != nil {
+		return "", 0, false
+	}
+
+	return v[:p], line, true
+}
@@ -136,6 +136,20 @@ more:
 			goto more
 		}
 	case ".json":
+		if filepath.Ext(base) == ".otlp" {
+			base = strings.TrimSuffix(base, ".otlp")
+
+			wrap = append(wrap, func(w io.Writer, c io.Closer) (io.Writer, io.Closer, error) {
+				wc := writeCloser(w, c)
+				w = convert.NewOTLP(wc)
+				c, _ = w.(io.Closer)
+
+				return w, c, nil
+			})
+
+			break
+		}
+
 		wrap = append(wrap, func(w io.Writer, c io.Closer) (io.Writer, io.Closer, error) {
 			w = convert.NewJSON(w)
 
@@ -356,6 +370,30 @@ more:
 		if ext == ".eazy" || ext == ".ez" {
 			goto more
 		}
+	case ".json":
+		if filepath.Ext(base) == ".otlp" {
+			base = strings.TrimSuffix(base, ".otlp")
+
+			wrap = append(wrap, func(r io.Reader, c io.Closer) (io.Reader, io.Closer, error) {
+				r = convert.NewOTLPReader(r)
+
+				return r, c, nil
+			})
+
+			break
+		}
+
+		wrap = append(wrap, func(r io.Reader, c io.Closer) (io.Reader, io.Closer, error) {
+			r = convert.NewJSONReader(r)
+
+			return r, c, nil
+		})
+	case ".logfmt":
+		wrap = append(wrap, func(r io.Reader, c io.Closer) (io.Reader, io.Closer, error) {
+			r = convert.NewLogfmtReader(r)
+
+			return r, c, nil
+		})
 	default:
 		return nil, nil, errors.New("unsupported format: %v", ext)
 	}
@@ -90,6 +90,10 @@ func TestFileWriter(t *testing.T) {
 				EazyBlockSize, EazyHTable)),
 		Closer: testFile("file.json.ez"),
 	}, w)
+
+	w, err = OpenWriter("file.otlp.json")
+	assert.NoError(t, err)
+	assert.Equal(t, convert.NewOTLP(testFile("file.otlp.json")), w)
 }
 
 func TestURLWriter(t *testing.T) { //nolint:dupl
@@ -171,6 +175,32 @@ func TestFileReader(t *testing.T) {
 	r, err = OpenReader(".tlog.ez")
 	assert.NoError(t, err)
 	assert.Equal(t, tlio.NopCloser{Reader: eazy.NewReader(os.Stdin)}, r)
+
+	r, err = OpenReader(".json")
+	assert.NoError(t, err)
+
+	_, ok := r.(tlio.NopCloser).Reader.(*convert.JSONReader)
+	assert.True(t, ok, "%T", r)
+
+	r, err = OpenReader("file.logfmt.ez")
+	assert.NoError(t, err)
+
+	rc, ok := r.(tlio.ReadCloser)
+	assert.True(t, ok, "%T", r)
+
+	_, ok = rc.Reader.(*convert.LogfmtReader)
+	assert.True(t, ok, "%T", rc.Reader)
+	assert.Equal(t, testFile("file.logfmt.ez"), rc.Closer)
+
+	r, err = OpenReader("file.otlp.json")
+	assert.NoError(t, err)
+
+	rc, ok = r.(tlio.ReadCloser)
+	assert.True(t, ok, "%T", r)
+
+	_, ok = rc.Reader.(*convert.OTLPReader)
+	assert.True(t, ok, "%T", rc.Reader)
+	assert.Equal(t, testFile("file.otlp.json"), rc.Closer)
 }
 
 func TestURLReader(t *testing.T) { //nolint:dupl
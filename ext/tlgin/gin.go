@@ -24,13 +24,8 @@ func CustomTracer(l *tlog.Logger) func(*gin.Context) {
 }
 
 func tracer(l *tlog.Logger, c *gin.Context) {
-	var trid tlog.ID
-	var err error
-
-	xtr := c.GetHeader(tlhttp.TraceIDKey)
-	if xtr != "" {
-		trid, err = tlog.IDFromString(xtr)
-	}
+	tc, err := tlhttp.ReadTraceContext(c.Request.Header)
+	trid := tc.SpanID
 
 	tr := l.NewSpan(0, trid, "http_request", "client_ip", c.ClientIP(), "method", c.Request.Method, "path", c.Request.URL.Path)
 	defer func() {
@@ -46,11 +41,14 @@ func tracer(l *tlog.Logger, c *gin.Context) {
 	}()
 
 	if err != nil {
-		tr.Printw("bad parent trace id", "id", xtr, "err", err)
+		tr.Printw("bad parent trace id", "err", err)
 	}
 
+	tc = tc.Child(tr)
+
 	ctx := c.Request.Context()
 	ctx = tlog.ContextWithSpan(ctx, tr)
+	ctx = tlhttp.ContextWithTraceContext(ctx, tc)
 	c.Request = c.Request.WithContext(ctx)
 
 	c.Set("tlog.par", trid)
@@ -58,6 +56,8 @@ func tracer(l *tlog.Logger, c *gin.Context) {
 	c.Set("tlog.span", tr)
 
 	c.Header(tlhttp.TraceIDKey, tr.ID.StringFull())
+	c.Header(tlhttp.TraceparentKey, tc.Traceparent())
+	c.Header(tlhttp.TracestateKey, tc.Tracestate())
 
 	c.Next()
 }
@@ -0,0 +1,197 @@
+// Package tlgrpc traces gRPC style calls.
+//
+// The package doesn't depend on google.golang.org/grpc.
+// Types here mirror grpc interceptor types, so interceptors are adapted by converting the arguments,
+// and they can be used with any RPC framework of the same shape.
+//
+// Trace context is read from and written to call metadata by Tracer.Incoming and Tracer.Outgoing.
+// They default to the package own metadata contexts, which never reach the wire.
+// grpc metadata functions have the same signatures:
+//
+//	t := &tlgrpc.Tracer{
+//		Incoming: metadata.ValueFromIncomingContext,
+//		Outgoing: metadata.AppendToOutgoingContext,
+//	}
+package tlgrpc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"tlog.app/go/errors"
+)
+
+type (
+	// MD is request metadata as grpc metadata.MD.
+	// Keys are lowercase.
+	MD map[string][]string
+
+	UnaryServerInfo struct {
+		Server     interface{}
+		FullMethod string
+	}
+
+	UnaryHandler func(ctx context.Context, req interface{}) (interface{}, error)
+
+	UnaryServerInterceptor func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error)
+
+	StreamServerInfo struct {
+		FullMethod     string
+		IsClientStream bool
+		IsServerStream bool
+	}
+
+	ServerStream interface {
+		Context() context.Context
+		SendMsg(m interface{}) error
+		RecvMsg(m interface{}) error
+	}
+
+	StreamHandler func(srv interface{}, stream ServerStream) error
+
+	StreamServerInterceptor func(srv interface{}, ss ServerStream, info *StreamServerInfo, handler StreamHandler) error
+
+	UnaryInvoker func(ctx context.Context, method string, req, reply interface{}) error
+
+	UnaryClientInterceptor func(ctx context.Context, method string, req, reply interface{}, invoker UnaryInvoker) error
+
+	StreamDesc struct {
+		StreamName    string
+		ClientStreams bool
+		ServerStreams bool
+	}
+
+	ClientStream interface {
+		Context() context.Context
+		SendMsg(m interface{}) error
+		RecvMsg(m interface{}) error
+		CloseSend() error
+	}
+
+	Streamer func(ctx context.Context, desc *StreamDesc, method string) (ClientStream, error)
+
+	StreamClientInterceptor func(ctx context.Context, desc *StreamDesc, method string, streamer Streamer) (ClientStream, error)
+
+	// Code is grpc status code.
+	Code uint32
+
+	// StatusError is implemented by errors carrying status code.
+	StatusError interface {
+		error
+		Code() Code
+	}
+
+	mdIncomingKey struct{}
+	mdOutgoingKey struct{}
+)
+
+// Status codes.
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+var codeNames = []string{
+	"OK",
+	"Canceled",
+	"Unknown",
+	"InvalidArgument",
+	"DeadlineExceeded",
+	"NotFound",
+	"AlreadyExists",
+	"PermissionDenied",
+	"ResourceExhausted",
+	"FailedPrecondition",
+	"Aborted",
+	"OutOfRange",
+	"Unimplemented",
+	"Internal",
+	"Unavailable",
+	"DataLoss",
+	"Unauthenticated",
+}
+
+// ErrorCode returns the status code of err.
+// Context errors are converted as grpc does.
+func ErrorCode(err error) Code {
+	if err == nil {
+		return OK
+	}
+
+	var se StatusError
+	if errors.As(err, &se) {
+		return se.Code()
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	}
+
+	return Unknown
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// Get returns values of the key.
+func (md MD) Get(k string) []string {
+	return md[strings.ToLower(k)]
+}
+
+// Set sets values of the key.
+func (md MD) Set(k string, vals ...string) {
+	md[strings.ToLower(k)] = vals
+}
+
+// Copy returns a deep copy of md.
+func (md MD) Copy() MD {
+	r := make(MD, len(md))
+
+	for k, v := range md {
+		r[k] = append([]string(nil), v...)
+	}
+
+	return r
+}
+
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdIncomingKey{}, md)
+}
+
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdIncomingKey{}).(MD)
+	return md, ok
+}
+
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdOutgoingKey{}, md)
+}
+
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdOutgoingKey{}).(MD)
+	return md, ok
+}
@@ -0,0 +1,337 @@
+package tlgrpc
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/ext/tlhttp"
+)
+
+type (
+	// Tracer provides interceptors starting a span per call.
+	//
+	// Span is propagated in W3C traceparent and tracestate metadata
+	// the same way tlhttp does it with headers.
+	// Spans are finished with the status code and error.
+	// Request and response payloads are logged if V topic is enabled.
+	Tracer struct {
+		// Logger is used for server spans.
+		// tlog.DefaultLogger is used if nil.
+		Logger *tlog.Logger
+
+		// V is a verbosity topic enabling payloads logging.
+		// Nothing is logged if empty.
+		V string
+
+		// Incoming returns incoming metadata values of the key.
+		// FromIncomingContext metadata is used if nil.
+		Incoming func(ctx context.Context, key string) []string
+
+		// Outgoing adds key-value pairs to outgoing metadata.
+		// NewOutgoingContext metadata is used if nil.
+		Outgoing func(ctx context.Context, kv ...string) context.Context
+	}
+
+	serverStream struct {
+		ServerStream
+
+		ctx context.Context
+		s   tlog.Span
+
+		dump bool
+
+		sent, recv atomic.Int64
+	}
+
+	clientStream struct {
+		ClientStream
+
+		ctx  context.Context
+		s    tlog.Span
+		desc *StreamDesc
+
+		dump bool
+
+		sent, recv atomic.Int64
+
+		once sync.Once
+	}
+)
+
+// Metadata keys.
+var (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+func New(l *tlog.Logger) *Tracer {
+	return &Tracer{Logger: l}
+}
+
+// UnaryServer is UnaryServerInterceptor.
+func (t *Tracer) UnaryServer(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (resp interface{}, err error) {
+	s, ctx := t.serverSpan(ctx, info.FullMethod)
+
+	defer func() {
+		finish(s, err)
+	}()
+
+	dump := t.dump(s)
+
+	if dump {
+		s.Printw("request", "payload", req)
+	}
+
+	resp, err = handler(ctx, req)
+
+	if dump && err == nil {
+		s.Printw("response", "payload", resp)
+	}
+
+	return resp, err
+}
+
+// StreamServer is StreamServerInterceptor.
+func (t *Tracer) StreamServer(srv interface{}, ss ServerStream, info *StreamServerInfo, handler StreamHandler) (err error) {
+	s, ctx := t.serverSpan(ss.Context(), info.FullMethod)
+
+	w := &serverStream{
+		ServerStream: ss,
+		ctx:          ctx,
+		s:            s,
+		dump:         t.dump(s),
+	}
+
+	defer func() {
+		finish(s, err, "sent", w.sent.Load(), "received", w.recv.Load())
+	}()
+
+	return handler(srv, w)
+}
+
+// UnaryClient is UnaryClientInterceptor.
+func (t *Tracer) UnaryClient(ctx context.Context, method string, req, reply interface{}, invoker UnaryInvoker) (err error) {
+	s, ctx := t.clientSpan(ctx, method)
+	if s.Logger == nil {
+		return invoker(ctx, method, req, reply)
+	}
+
+	defer func() {
+		finish(s, err)
+	}()
+
+	dump := t.dump(s)
+
+	if dump {
+		s.Printw("request", "payload", req)
+	}
+
+	err = invoker(ctx, method, req, reply)
+
+	if dump && err == nil {
+		s.Printw("response", "payload", reply)
+	}
+
+	return err
+}
+
+// StreamClient is StreamClientInterceptor.
+// The span is finished when RecvMsg returns an error, io.EOF including,
+// or after the response is received if it's not a server stream.
+func (t *Tracer) StreamClient(ctx context.Context, desc *StreamDesc, method string, streamer Streamer) (ClientStream, error) {
+	s, ctx := t.clientSpan(ctx, method)
+	if s.Logger == nil {
+		return streamer(ctx, desc, method)
+	}
+
+	cs, err := streamer(ctx, desc, method)
+	if err != nil {
+		finish(s, err)
+
+		return nil, err
+	}
+
+	return &clientStream{
+		ClientStream: cs,
+		ctx:          ctx,
+		s:            s,
+		desc:         desc,
+		dump:         t.dump(s),
+	}, nil
+}
+
+func (t *Tracer) serverSpan(ctx context.Context, method string) (tlog.Span, context.Context) {
+	l := t.Logger
+	if l == nil {
+		l = tlog.DefaultLogger
+	}
+
+	incoming := t.Incoming
+	if incoming == nil {
+		incoming = incomingValue
+	}
+
+	var tc tlhttp.TraceContext
+	var err error
+
+	tp := incoming(ctx, TraceparentKey)
+
+	if len(tp) != 0 {
+		tc, err = tlhttp.ParseTraceContext(tp[0], strings.Join(incoming(ctx, TracestateKey), ","))
+	}
+
+	s := l.NewSpan(1, tc.SpanID, "grpc_server", "method", method)
+
+	if err != nil {
+		s.Printw("bad parent trace id", "traceparent", tp, "err", err)
+		tc = tlhttp.TraceContext{}
+	}
+
+	ctx = tlog.ContextWithSpan(ctx, s)
+	ctx = tlhttp.ContextWithTraceContext(ctx, tc.Child(s))
+
+	return s, ctx
+}
+
+func (t *Tracer) dump(s tlog.Span) bool {
+	return t.V != "" && s.If(t.V)
+}
+
+// clientSpan spawns a child span and puts its trace context into the outgoing metadata.
+// It returns empty span and the same context if there is no span in ctx.
+func (t *Tracer) clientSpan(ctx context.Context, method string) (tlog.Span, context.Context) {
+	tc, _ := tlhttp.TraceContextFromContext(ctx)
+
+	s, ctx := tlog.SpawnFromContextAndWrap(ctx, "grpc_client", "method", method)
+	if s.Logger == nil {
+		return s, ctx
+	}
+
+	tc = tc.Child(s)
+
+	outgoing := t.Outgoing
+	if outgoing == nil {
+		outgoing = appendOutgoing
+	}
+
+	ctx = outgoing(ctx, TraceparentKey, tc.Traceparent(), TracestateKey, tc.Tracestate())
+
+	return s, ctx
+}
+
+func incomingValue(ctx context.Context, key string) []string {
+	md, _ := FromIncomingContext(ctx)
+
+	return md.Get(key)
+}
+
+// appendOutgoing sets the keys in a copy of the outgoing metadata.
+func appendOutgoing(ctx context.Context, kv ...string) context.Context {
+	md, ok := FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = MD{}
+	}
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		md.Set(kv[i], kv[i+1])
+	}
+
+	return NewOutgoingContext(ctx, md)
+}
+
+func finish(s tlog.Span, err error, kvs ...interface{}) {
+	kvs = append([]interface{}{"code", ErrorCode(err).String()}, kvs...)
+
+	if err != nil {
+		kvs = append(kvs, "err", err)
+	}
+
+	s.Finish(kvs...)
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err != nil {
+		return err
+	}
+
+	s.sent.Add(1)
+
+	if s.dump {
+		s.s.Printw("send", "payload", m)
+	}
+
+	return nil
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	s.recv.Add(1)
+
+	if s.dump {
+		s.s.Printw("recv", "payload", m)
+	}
+
+	return nil
+}
+
+func (s *clientStream) Context() context.Context { return s.ctx }
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil {
+		return err
+	}
+
+	s.sent.Add(1)
+
+	if s.dump {
+		s.s.Printw("send", "payload", m)
+	}
+
+	return nil
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if errors.Is(err, io.EOF) {
+		s.finish(nil)
+		return err
+	}
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+
+	s.recv.Add(1)
+
+	if s.dump {
+		s.s.Printw("recv", "payload", m)
+	}
+
+	if !s.desc.ServerStreams {
+		s.finish(nil)
+	}
+
+	return nil
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		finish(s.s, err, "sent", s.sent.Load(), "received", s.recv.Load())
+	})
+}
@@ -0,0 +1,285 @@
+package tlgrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/convert"
+)
+
+type (
+	statusError struct {
+		code Code
+		msg  string
+	}
+
+	// testStream is a server or client stream receiving msgs and recording sent ones.
+	testStream struct {
+		ctx context.Context
+
+		msgs []string
+		sent []string
+	}
+
+	// wireKey is where a framework keeps metadata in the test bridge.
+	wireKey struct{ incoming bool }
+
+	testEvent struct {
+		S       tlog.ID `json:"_s"`
+		P       tlog.ID `json:"_p"`
+		K       string  `json:"_k"`
+		M       string  `json:"_m"`
+		Method  string  `json:"method"`
+		Code    string  `json:"code"`
+		Err     string  `json:"err"`
+		Sent    int     `json:"sent"`
+		Recv    int     `json:"received"`
+		Payload string  `json:"payload"`
+	}
+)
+
+func TestUnary(t *testing.T) {
+	var b low.Buf
+
+	l := tlog.New(convert.NewJSON(&b))
+	l.SetVerbosity("tlgrpc")
+
+	tr := &Tracer{Logger: l, V: "tlgrpc"}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if tlog.SpanFromContext(ctx).Logger == nil {
+			return nil, statusError{Internal, "no span"}
+		}
+
+		if req.(string) == "fail" {
+			return nil, statusError{NotFound, "not found"}
+		}
+
+		return "hello " + req.(string), nil
+	}
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}) error {
+		md, _ := FromOutgoingContext(ctx)
+		ctx = NewIncomingContext(context.Background(), md)
+
+		resp, err := tr.UnaryServer(ctx, req, &UnaryServerInfo{FullMethod: method}, handler)
+		if err != nil {
+			return err
+		}
+
+		*reply.(*string) = resp.(string)
+
+		return nil
+	}
+
+	root := l.Start("root")
+	ctx := tlog.ContextWithSpan(context.Background(), root)
+
+	var resp string
+
+	err := tr.UnaryClient(ctx, "/test.Service/Hello", "world", &resp, invoker)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", resp)
+
+	err = tr.UnaryClient(ctx, "/test.Service/Hello", "fail", &resp, invoker)
+	assert.Equal(t, NotFound, ErrorCode(err))
+
+	evs := parseEvents(t, b)
+
+	client := findEvents(evs, "s", "grpc_client")
+	server := findEvents(evs, "s", "grpc_server")
+
+	if !assert.Equal(t, 2, len(client)) || !assert.Equal(t, 2, len(server)) {
+		return
+	}
+
+	for i := range 2 {
+		assert.Equal(t, root.ID, client[i].P)
+		assert.Equal(t, client[i].S, server[i].P, "server span is a child of client span")
+	}
+
+	assert.Equal(t, []testEvent{
+		{S: server[0].S, M: "request", Payload: "world"},
+		{S: server[0].S, M: "response", Payload: "hello world"},
+		{S: server[0].S, K: "f", Code: "OK"},
+	}, spanEvents(evs, server[0].S))
+
+	assert.Equal(t, []testEvent{
+		{S: client[1].S, M: "request", Payload: "fail"},
+		{S: client[1].S, K: "f", Code: "NotFound", Err: "not found"},
+	}, spanEvents(evs, client[1].S))
+
+	// no span, no tracing
+	err = tr.UnaryClient(context.Background(), "/test.Service/Hello", "world", &resp, func(ctx context.Context, method string, req, reply interface{}) error {
+		_, ok := FromOutgoingContext(ctx)
+		assert.False(t, ok)
+
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestStream(t *testing.T) {
+	var b low.Buf
+
+	l := tlog.New(convert.NewJSON(&b))
+
+	tr := New(l)
+	tr.Incoming = wireIncoming
+	tr.Outgoing = wireOutgoing
+
+	handler := func(srv interface{}, ss ServerStream) error {
+		for {
+			var m string
+
+			err := ss.RecvMsg(&m)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			err = ss.SendMsg("echo " + m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// the server is done before the client starts reading
+	streamer := func(ctx context.Context, desc *StreamDesc, method string) (ClientStream, error) {
+		md, _ := ctx.Value(wireKey{}).(MD)
+		sctx := context.WithValue(context.Background(), wireKey{incoming: true}, md)
+
+		ss := &testStream{ctx: sctx, msgs: []string{"a", "b"}}
+
+		err := tr.StreamServer(nil, ss, &StreamServerInfo{FullMethod: method, IsClientStream: true, IsServerStream: true}, handler)
+		if err != nil {
+			return nil, err
+		}
+
+		return &testStream{ctx: ctx, msgs: ss.sent}, nil
+	}
+
+	root := l.Start("root")
+	ctx := tlog.ContextWithSpan(context.Background(), root)
+
+	cs, err := tr.StreamClient(ctx, &StreamDesc{ClientStreams: true, ServerStreams: true}, "/test.Service/Echo", streamer)
+	assert.NoError(t, err)
+
+	for _, m := range []string{"a", "b"} {
+		err = cs.SendMsg(m)
+		assert.NoError(t, err)
+
+		var r string
+
+		err = cs.RecvMsg(&r)
+		assert.NoError(t, err)
+		assert.Equal(t, "echo "+m, r)
+	}
+
+	err = cs.CloseSend()
+	assert.NoError(t, err)
+
+	var r string
+
+	err = cs.RecvMsg(&r)
+	assert.ErrorIs(t, err, io.EOF)
+
+	evs := parseEvents(t, b)
+
+	client := findEvents(evs, "s", "grpc_client")
+	server := findEvents(evs, "s", "grpc_server")
+
+	if !assert.Equal(t, 1, len(client)) || !assert.Equal(t, 1, len(server)) {
+		return
+	}
+
+	assert.Equal(t, client[0].S, server[0].P, "trace context is passed through the bridge")
+
+	assert.Equal(t, []testEvent{{S: client[0].S, K: "f", Code: "OK", Sent: 2, Recv: 2}}, spanEvents(evs, client[0].S))
+	assert.Equal(t, []testEvent{{S: server[0].S, K: "f", Code: "OK", Sent: 2, Recv: 2}}, spanEvents(evs, server[0].S))
+}
+
+// wireIncoming and wireOutgoing are metadata bridge stand-ins
+// for grpc metadata.ValueFromIncomingContext and metadata.AppendToOutgoingContext.
+func wireIncoming(ctx context.Context, key string) []string {
+	md, _ := ctx.Value(wireKey{incoming: true}).(MD)
+
+	return md.Get(key)
+}
+
+func wireOutgoing(ctx context.Context, kv ...string) context.Context {
+	md, _ := ctx.Value(wireKey{}).(MD)
+	md = md.Copy()
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		md.Set(kv[i], append(md.Get(kv[i]), kv[i+1])...)
+	}
+
+	return context.WithValue(ctx, wireKey{}, md)
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+
+func (s *testStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m.(string))
+
+	return nil
+}
+
+func (s *testStream) RecvMsg(m interface{}) error {
+	if len(s.msgs) == 0 {
+		return io.EOF
+	}
+
+	*m.(*string) = s.msgs[0]
+	s.msgs = s.msgs[1:]
+
+	return nil
+}
+
+func (s *testStream) CloseSend() error { return nil }
+
+func (e statusError) Error() string { return e.msg }
+func (e statusError) Code() Code    { return e.code }
+
+func parseEvents(t *testing.T, b []byte) (evs []testEvent) {
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var ev testEvent
+
+		err := json.Unmarshal([]byte(line), &ev)
+		assert.NoError(t, err, "%s", line)
+
+		evs = append(evs, ev)
+	}
+
+	return evs
+}
+
+func findEvents(evs []testEvent, kind, msg string) (r []testEvent) {
+	for _, ev := range evs {
+		if ev.K == kind && ev.M == msg {
+			r = append(r, ev)
+		}
+	}
+
+	return r
+}
+
+// spanEvents returns span events except the start one.
+func spanEvents(evs []testEvent, id tlog.ID) (r []testEvent) {
+	for _, ev := range evs {
+		if ev.S == id && ev.K != "s" {
+			r = append(r, ev)
+		}
+	}
+
+	return r
+}
@@ -8,34 +8,65 @@ import (
 
 var TraceIDKey = "Traceid"
 
+// SpawnOrStart starts a span continuing the caller trace if there is one.
 func SpawnOrStart(w http.ResponseWriter, req *http.Request, kvs ...interface{}) tlog.Span {
-	return spawnOrStart(tlog.DefaultLogger, w, req, kvs)
+	tr, _ := spawnOrStart(tlog.DefaultLogger, 2, w, req, kvs)
+
+	return tr
 }
 
+// SpawnOrStartLogger is SpawnOrStart with the Logger.
 func SpawnOrStartLogger(l *tlog.Logger, w http.ResponseWriter, req *http.Request, kvs ...interface{}) tlog.Span {
-	return spawnOrStart(l, w, req, kvs)
+	tr, _ := spawnOrStart(l, 2, w, req, kvs)
+
+	return tr
 }
 
-func spawnOrStart(l *tlog.Logger, w http.ResponseWriter, req *http.Request, kvs []interface{}) tlog.Span {
-	var trid tlog.ID
-	var err error
+// SpawnOrStartRequest is SpawnOrStart also returning a copy of req
+// with the span and its TraceContext in the context,
+// so requests made with it through Transport continue the caller trace
+// keeping its trace-id and tracestate.
+func SpawnOrStartRequest(w http.ResponseWriter, req *http.Request, kvs ...interface{}) (tlog.Span, *http.Request) {
+	tr, tc := spawnOrStart(tlog.DefaultLogger, 2, w, req, kvs)
 
-	xtr := req.Header.Get(TraceIDKey)
-	if xtr != "" {
-		trid, err = tlog.IDFromString(xtr)
-	}
+	return tr, withContext(req, tr, tc)
+}
 
-	tr := l.NewSpan(2, trid, "http_request", append([]interface{}{
+// SpawnOrStartRequestLogger is SpawnOrStartRequest with the Logger.
+func SpawnOrStartRequestLogger(l *tlog.Logger, w http.ResponseWriter, req *http.Request, kvs ...interface{}) (tlog.Span, *http.Request) {
+	tr, tc := spawnOrStart(l, 2, w, req, kvs)
+
+	return tr, withContext(req, tr, tc)
+}
+
+// spawnOrStart starts a span continuing the caller trace if there is one.
+// d is the span caller depth, negative means no caller.
+// Both TraceIDKey and W3C Trace Context headers are set in the response.
+func spawnOrStart(l *tlog.Logger, d int, w http.ResponseWriter, req *http.Request, kvs []interface{}) (tlog.Span, TraceContext) {
+	tc, err := ReadTraceContext(req.Header)
+
+	tr := l.NewSpan(d, tc.SpanID, "http_request", append([]interface{}{
 		"client", req.RemoteAddr,
 		"method", req.Method,
 		"path", req.URL.Path,
 	}, kvs...)...)
 
 	if err != nil {
-		tr.Printw("bad parent trace id", "id", xtr, "err", err)
+		tr.Printw("bad parent trace id", "err", err)
 	}
 
+	tc = tc.Child(tr)
+
 	w.Header().Set(TraceIDKey, tr.ID.StringFull())
+	tc.SetHeaders(w.Header())
 
-	return tr
+	return tr, tc
+}
+
+func withContext(req *http.Request, tr tlog.Span, tc TraceContext) *http.Request {
+	ctx := req.Context()
+	ctx = tlog.ContextWithSpan(ctx, tr)
+	ctx = ContextWithTraceContext(ctx, tc)
+
+	return req.WithContext(ctx)
 }
@@ -0,0 +1,125 @@
+package tlhttp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/low"
+)
+
+type (
+	// responseWriter captures response status code and body size.
+	responseWriter struct {
+		http.ResponseWriter
+
+		status  int
+		written int64
+	}
+)
+
+// Middleware traces requests as SpawnOrStart does.
+// The span and its TraceContext are put into the request context.
+// Panics are recovered and logged with the stack trace,
+// 500 status is sent if the response is not started yet.
+// The span is finished with the response status code, body size, and panic error.
+func Middleware(l *tlog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			tr, tc := spawnOrStart(l, -1, w, req, nil) // no meaningful caller in a middleware
+
+			rw := &responseWriter{ResponseWriter: w}
+
+			defer func() {
+				var err error
+
+				p := recover()
+
+				switch {
+				case p == nil:
+				case p == http.ErrAbortHandler: //nolint:errorlint
+					err = errors.New("aborted")
+				default:
+					s := debug.Stack()
+
+					tr.Printw("panic", "panic", p, "panic_type", tlog.FormatNext("%T"), p, "stack_trace", low.UnsafeBytesToString(s), tlog.KeyLogLevel, tlog.Error)
+
+					if rw.status == 0 {
+						rw.WriteHeader(http.StatusInternalServerError)
+					}
+
+					err = errors.New("panic: %v", p)
+				}
+
+				kvs := []interface{}{"status_code", rw.Status(), "written", rw.written}
+
+				if err != nil {
+					kvs = append(kvs, "err", err)
+				}
+
+				tr.Finish(kvs...)
+
+				if p == http.ErrAbortHandler { //nolint:errorlint
+					panic(p)
+				}
+			}()
+
+			next.ServeHTTP(rw, withContext(req, tr, tc))
+		})
+	}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (n int, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err = w.ResponseWriter.Write(p)
+	w.written += int64(n)
+
+	return n, err
+}
+
+// Status returns the response status code.
+// Handler returned without writing anything results in 200.
+func (w *responseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+func (w *responseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+
+	return c, rw, err
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
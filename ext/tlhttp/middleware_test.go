@@ -0,0 +1,118 @@
+package tlhttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/convert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var b low.Buf
+
+	l := tlog.New(convert.NewJSON(&b))
+
+	var inner tlog.Span
+
+	h := Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		inner = tlog.SpanFromContext(req.Context())
+
+		if req.URL.Path == "/panic" {
+			panic("oops")
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	type event struct {
+		S      tlog.ID `json:"_s"`
+		K      string  `json:"_k"`
+		C      string  `json:"_c"`
+		M      string  `json:"_m"`
+		L      int     `json:"_l"`
+		Status int     `json:"status_code"`
+		N      int     `json:"written"`
+		Err    string  `json:"err"`
+		Stack  string  `json:"stack_trace"`
+	}
+
+	events := func() (evs []event) {
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			var ev event
+
+			err := json.Unmarshal([]byte(line), &ev)
+			assert.NoError(t, err, "%s", line)
+
+			evs = append(evs, ev)
+		}
+
+		b = b[:0]
+
+		return evs
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/ok", nil))
+
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Equal(t, inner.ID.StringFull(), rw.Header().Get(TraceIDKey))
+
+	evs := events()
+	if assert.Equal(t, 2, len(evs)) {
+		assert.Equal(t, event{S: inner.ID, K: "s", M: "http_request"}, evs[0])
+		assert.Equal(t, event{S: inner.ID, K: "f", Status: 201, N: 5}, evs[1])
+	}
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rw.Code)
+
+	evs = events()
+	if assert.Equal(t, 3, len(evs)) {
+		assert.Equal(t, "panic", evs[1].M)
+		assert.Equal(t, int(tlog.Error), evs[1].L)
+		assert.True(t, strings.Contains(evs[1].Stack, "middleware_test.go"))
+		assert.Equal(t, event{S: inner.ID, K: "f", Status: 500, Err: "panic: oops"}, evs[2])
+	}
+
+	// http.ResponseController reaches the underlying writer
+	h = Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, "data")
+
+		err := http.NewResponseController(w).Flush()
+		assert.NoError(t, err)
+	}))
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.True(t, rw.Flushed)
+
+	b = b[:0]
+
+	// failed hijack doesn't change the status
+	h = Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		inner = tlog.SpanFromContext(req.Context())
+
+		_, _, err := http.NewResponseController(w).Hijack()
+		assert.Error(t, err)
+	}))
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	evs = events()
+	if assert.Equal(t, 2, len(evs)) {
+		assert.Equal(t, event{S: inner.ID, K: "f", Status: 200}, evs[1])
+	}
+}
@@ -0,0 +1,262 @@
+package tlhttp
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+)
+
+type (
+	// TraceContext is W3C Trace Context of a span.
+	//
+	// W3C trace-id is 16 bytes as tlog.ID is, but parent-id is only 8 bytes.
+	// So the first half of the tlog span id is used as the parent-id,
+	// and the full id is passed in the tracestate "tlog" entry.
+	// That way spans are linked exactly if both sides use tlog.
+	//
+	// If the caller is not tlog instrumented, the parent tlog.ID is
+	// the parent-id followed by the second half of the trace-id.
+	// That is the same mapping the OTLP reader uses, so the spans link
+	// if the caller traces are imported.
+	TraceContext struct {
+		TraceID tlog.ID
+		SpanID  tlog.ID
+		Flags   byte
+
+		// State is tracestate entries of other vendors.
+		State string
+	}
+
+	ctxtracekey struct{}
+)
+
+var (
+	TraceparentKey = "Traceparent"
+	TracestateKey  = "Tracestate"
+)
+
+// TracestateVendor is the tracestate entry key carrying the full tlog span id.
+const TracestateVendor = "tlog"
+
+// Trace flags.
+const (
+	TraceFlagSampled = 1 << iota
+)
+
+const maxTracestateEntries = 32
+
+// ContextWithTraceContext saves trace id, flags, and state for outgoing requests.
+// Span id is taken from the context span at the moment of the request.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, ctxtracekey{}, tc)
+}
+
+// TraceContextFromContext returns TraceContext of the context span.
+// If there is no saved TraceContext, the span is considered to be a trace root,
+// so its id is used as the trace id.
+// It returns false if there is no span and no TraceContext.
+func TraceContextFromContext(ctx context.Context) (tc TraceContext, ok bool) {
+	tc, ok = ctx.Value(ctxtracekey{}).(TraceContext)
+
+	s := tlog.SpanFromContext(ctx)
+	if s.ID != (tlog.ID{}) {
+		tc.SpanID = s.ID
+	}
+
+	if !ok && tc.SpanID != (tlog.ID{}) {
+		tc.TraceID = tc.SpanID
+		tc.Flags = TraceFlagSampled
+		ok = true
+	}
+
+	return tc, ok
+}
+
+// ReadTraceContext reads the caller TraceContext from request headers.
+// SpanID is the remote parent span id.
+// If there is no traceparent, TraceIDKey header is used,
+// and TraceID is set to the parent id as the root is unknown.
+// It returns zero TraceContext and nil error if there are no such headers.
+func ReadTraceContext(h http.Header) (tc TraceContext, err error) {
+	if tp := h.Get(TraceparentKey); tp != "" {
+		tc, err = ParseTraceContext(tp, strings.Join(h.Values(TracestateKey), ","))
+		if err != nil {
+			return TraceContext{}, errors.Wrap(err, "%v: %q", TraceparentKey, tp)
+		}
+
+		return tc, nil
+	}
+
+	if xtr := h.Get(TraceIDKey); xtr != "" {
+		tc.SpanID, err = tlog.IDFromString(xtr)
+		if err != nil {
+			return TraceContext{}, errors.Wrap(err, "%v: %q", TraceIDKey, xtr)
+		}
+
+		tc.TraceID = tc.SpanID
+		tc.Flags = TraceFlagSampled
+	}
+
+	return tc, nil
+}
+
+// Child returns TraceContext of the span started from the tc parent.
+// If tc is zero the span becomes the trace root.
+func (tc TraceContext) Child(s tlog.Span) TraceContext {
+	if tc.TraceID == (tlog.ID{}) {
+		tc.TraceID = s.ID
+		tc.Flags = TraceFlagSampled
+	}
+
+	tc.SpanID = s.ID
+
+	return tc
+}
+
+// ParseTraceContext parses traceparent and tracestate header values.
+func ParseTraceContext(traceparent, tracestate string) (tc TraceContext, err error) {
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	const size = 2 + 1 + 32 + 1 + 16 + 1 + 2
+
+	tp := strings.TrimSpace(traceparent)
+
+	if len(tp) < size || tp[2] != '-' || tp[35] != '-' || tp[52] != '-' {
+		return tc, errors.New("malformed traceparent")
+	}
+
+	ver, err := hexByte(tp[:2])
+	if err != nil || ver == 0xff {
+		return tc, errors.New("bad traceparent version")
+	}
+
+	// future versions may append fields
+	if ver == 0 && len(tp) != size || ver != 0 && len(tp) > size && tp[size] != '-' {
+		return tc, errors.New("malformed traceparent")
+	}
+
+	_, err = hex.Decode(tc.TraceID[:], []byte(tp[3:35]))
+	if err != nil || tc.TraceID == (tlog.ID{}) || tp[3:35] != strings.ToLower(tp[3:35]) {
+		return tc, errors.New("bad trace id")
+	}
+
+	var par [8]byte
+
+	_, err = hex.Decode(par[:], []byte(tp[36:52]))
+	if err != nil || par == [8]byte{} || tp[36:52] != strings.ToLower(tp[36:52]) {
+		return tc, errors.New("bad parent id")
+	}
+
+	tc.Flags, err = hexByte(tp[53:55])
+	if err != nil {
+		return tc, errors.New("bad trace flags")
+	}
+
+	var full tlog.ID
+	var fullOK bool
+
+	tc.State, full, fullOK = parseTracestate(tracestate)
+
+	if fullOK && [8]byte(full[:8]) == par {
+		tc.SpanID = full
+	} else {
+		copy(tc.SpanID[:8], par[:])
+		copy(tc.SpanID[8:], tc.TraceID[8:])
+	}
+
+	return tc, nil
+}
+
+// SetHeaders sets traceparent and tracestate headers.
+func (tc TraceContext) SetHeaders(h http.Header) {
+	h.Set(TraceparentKey, tc.Traceparent())
+	h.Set(TracestateKey, tc.Tracestate())
+}
+
+// Traceparent formats traceparent header value.
+func (tc TraceContext) Traceparent() string {
+	var b [55]byte
+
+	copy(b[:], "00-")
+	hex.Encode(b[3:], tc.TraceID[:])
+	b[35] = '-'
+	hex.Encode(b[36:], tc.SpanID[:8])
+	b[52] = '-'
+	hex.Encode(b[53:], []byte{tc.Flags})
+
+	return string(b[:])
+}
+
+// Tracestate formats tracestate header value.
+// The tlog entry goes first as the most recently updated one.
+func (tc TraceContext) Tracestate() string {
+	s := TracestateVendor + "=" + hex.EncodeToString(tc.SpanID[:])
+
+	if tc.State == "" {
+		return s
+	}
+
+	return s + "," + tc.State
+}
+
+// parseTracestate splits tlog entry from the others.
+func parseTracestate(ts string) (state string, id tlog.ID, ok bool) {
+	var b strings.Builder
+	var n int
+
+	for _, e := range strings.Split(ts, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+
+		k, v, found := strings.Cut(e, "=")
+		if !found {
+			continue
+		}
+
+		if k == TracestateVendor {
+			if ok {
+				continue
+			}
+
+			if len(v) != 2*len(id) {
+				continue
+			}
+
+			_, err := hex.Decode(id[:], []byte(v))
+			ok = err == nil
+
+			continue
+		}
+
+		if n == maxTracestateEntries-1 {
+			break
+		}
+
+		if n != 0 {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(e)
+		n++
+	}
+
+	return b.String(), id, ok
+}
+
+func hexByte(s string) (byte, error) {
+	var b [1]byte
+
+	if s != strings.ToLower(s) {
+		return 0, errors.New("uppercase hex")
+	}
+
+	_, err := hex.Decode(b[:], []byte(s))
+
+	return b[0], err
+}
@@ -0,0 +1,131 @@
+package tlhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nikandfor/assert"
+
+	"tlog.app/go/tlog"
+)
+
+func TestParseTraceContext(t *testing.T) {
+	tc, err := ParseTraceContext("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7")
+	assert.NoError(t, err)
+
+	tid, _ := tlog.IDFromString("0af7651916cd43dd8448eb211c80319c")
+	sid, _ := tlog.IDFromString("b7ad6b71692033318448eb211c80319c")
+
+	assert.Equal(t, TraceContext{
+		TraceID: tid,
+		SpanID:  sid,
+		Flags:   TraceFlagSampled,
+		State:   "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7",
+	}, tc)
+
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", tc.Traceparent())
+
+	full, _ := tlog.IDFromString("b7ad6b7169203331000102030405060f")
+
+	tc, err = ParseTraceContext("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", "rojo=1, tlog=b7ad6b7169203331000102030405060f")
+	assert.NoError(t, err)
+	assert.Equal(t, full, tc.SpanID)
+	assert.Equal(t, byte(0), tc.Flags)
+	assert.Equal(t, "rojo=1", tc.State)
+
+	// tlog entry of another span is ignored
+	tc, err = ParseTraceContext("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "tlog=c7ad6b7169203331000102030405060f")
+	assert.NoError(t, err)
+	assert.Equal(t, sid, tc.SpanID)
+
+	// future version with extra fields
+	_, err = ParseTraceContext("01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-what-is-this", "")
+	assert.NoError(t, err)
+
+	for _, tp := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-x1",
+	} {
+		_, err = ParseTraceContext(tp, "")
+		assert.Error(t, err, "%q", tp)
+	}
+}
+
+func TestSpawnOrStartTraceparent(t *testing.T) {
+	l := tlog.New(io.Discard)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentKey, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	req.Header.Set(TracestateKey, "rojo=00f067aa0ba902b7")
+
+	rw := httptest.NewRecorder()
+
+	tr, tc := spawnOrStart(l, 0, rw, req, nil)
+
+	tid, _ := tlog.IDFromString("0af7651916cd43dd8448eb211c80319c")
+
+	assert.Equal(t, tid, tc.TraceID)
+	assert.Equal(t, tr.ID, tc.SpanID)
+
+	// the next hop restores the full span id
+	next, err := ReadTraceContext(rw.Header())
+	assert.NoError(t, err)
+	assert.Equal(t, tc, next)
+	assert.Equal(t, "tlog="+tr.ID.StringFull()+",rojo=00f067aa0ba902b7", rw.Header().Get(TracestateKey))
+
+	// legacy header and the trace root
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceIDKey, tr.ID.StringFull())
+
+	_, tc = spawnOrStart(l, 0, httptest.NewRecorder(), req, nil)
+	assert.Equal(t, tr.ID, tc.TraceID)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	root, tc := spawnOrStart(l, 0, httptest.NewRecorder(), req, nil)
+	assert.Equal(t, root.ID, tc.TraceID)
+	assert.Equal(t, root.ID, tc.SpanID)
+}
+
+func TestSpawnOrStartPropagate(t *testing.T) {
+	l := tlog.New(io.Discard)
+
+	var got http.Header
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header.Clone()
+	}))
+	defer srv.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentKey, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	req.Header.Set(TracestateKey, "rojo=00f067aa0ba902b7")
+
+	tr, sreq := SpawnOrStartRequestLogger(l, httptest.NewRecorder(), req)
+
+	assert.Equal(t, tr, tlog.SpanFromContext(sreq.Context()))
+	assert.Equal(t, tlog.Span{}, tlog.SpanFromContext(req.Context()), "original request is not modified")
+
+	out, err := http.NewRequestWithContext(sreq.Context(), http.MethodGet, srv.URL, nil)
+	assert.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(out)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+
+	tc, err := ReadTraceContext(got)
+	assert.NoError(t, err)
+
+	tid, _ := tlog.IDFromString("0af7651916cd43dd8448eb211c80319c")
+
+	assert.Equal(t, tid, tc.TraceID)
+	assert.Equal(t, "rojo=00f067aa0ba902b7", tc.State)
+	assert.True(t, tc.SpanID != tr.ID && tc.SpanID != (tlog.ID{}), "client span id: %v", tc.SpanID)
+}
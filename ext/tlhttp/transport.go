@@ -0,0 +1,166 @@
+package tlhttp
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+)
+
+type (
+	// Transport traces client requests.
+	//
+	// A child span of the request context span is started for each request.
+	// Requests without a span in the context are passed as is.
+	// Trace headers are injected as in SpawnOrStart response.
+	//
+	// The span is finished when the response body is read to the end or closed,
+	// so its duration includes reading the body.
+	// Method and url are logged on start; status code, bytes sent and received, and error on finish.
+	Transport struct {
+		// http.DefaultTransport is used if nil.
+		http.RoundTripper
+
+		// V is a verbosity topic. Request and response bodies are dumped if it's enabled.
+		// Nothing is dumped if empty.
+		V string
+	}
+
+	// transportBody counts read bytes and dumps them if needed.
+	transportBody struct {
+		io.ReadCloser
+
+		dump io.Writer
+		n    *atomic.Int64
+
+		finish func(err error)
+	}
+
+	transportSpan struct {
+		tlog.Span
+
+		sent, recv atomic.Int64
+
+		once sync.Once
+	}
+)
+
+// NewTransport wraps rt with Transport.
+func NewTransport(rt http.RoundTripper) *Transport {
+	return &Transport{RoundTripper: rt}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	rt := t.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	ctx := req.Context()
+
+	parent, ok := TraceContextFromContext(ctx)
+	if !ok {
+		return rt.RoundTrip(req)
+	}
+
+	s := tlog.SpawnFromContext(ctx, "http_client_request", "method", req.Method, "url", req.URL)
+	if s.Logger == nil {
+		return rt.RoundTrip(req)
+	}
+
+	ts := &transportSpan{Span: s}
+
+	tc := parent.Child(s)
+
+	req = req.Clone(tlog.ContextWithSpan(ctx, s))
+	req.Header.Set(TraceIDKey, s.ID.StringFull())
+	tc.SetHeaders(req.Header)
+
+	dump := t.V != "" && s.If(t.V)
+
+	if req.Body != nil && req.Body != http.NoBody {
+		b := &transportBody{
+			ReadCloser: req.Body,
+			n:          &ts.sent,
+		}
+
+		if dump {
+			b.dump = s.DumpWriter(0, "request body", "data")
+		}
+
+		req.Body = b
+	}
+
+	resp, err = rt.RoundTrip(req)
+	if err != nil {
+		ts.finish(nil, err)
+
+		return nil, err
+	}
+
+	b := &transportBody{
+		ReadCloser: resp.Body,
+		n:          &ts.recv,
+		finish: func(err error) {
+			ts.finish(resp, err)
+		},
+	}
+
+	if dump {
+		b.dump = s.DumpWriter(0, "response body", "data", "status_code", resp.StatusCode)
+	}
+
+	resp.Body = b
+
+	return resp, nil
+}
+
+func (s *transportSpan) finish(resp *http.Response, err error) {
+	s.once.Do(func() {
+		kvs := []interface{}{"sent", s.sent.Load()}
+
+		if resp != nil {
+			kvs = append(kvs, "status_code", resp.StatusCode, "received", s.recv.Load())
+		}
+
+		if err != nil {
+			kvs = append(kvs, "err", err)
+		}
+
+		s.Finish(kvs...)
+	})
+}
+
+func (b *transportBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+
+	b.n.Add(int64(n))
+
+	if n != 0 && b.dump != nil {
+		_, _ = b.dump.Write(p[:n])
+	}
+
+	if err != nil && b.finish != nil {
+		if errors.Is(err, io.EOF) {
+			b.finish(nil)
+		} else {
+			b.finish(err)
+		}
+	}
+
+	return n, err
+}
+
+func (b *transportBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	if b.finish != nil {
+		b.finish(nil)
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package tlhttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/convert"
+)
+
+func TestTransport(t *testing.T) {
+	var b low.Buf
+
+	l := tlog.New(convert.NewJSON(&b))
+	l.SetVerbosity("tlhttp")
+
+	var got http.Header
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header.Clone()
+
+		_, _ = io.Copy(io.Discard, req.Body)
+		_, _ = w.Write([]byte("response"))
+	}))
+	defer srv.Close()
+
+	cl := &http.Client{Transport: &Transport{V: "tlhttp"}}
+
+	root := l.Start("root")
+	ctx := tlog.ContextWithSpan(context.Background(), root)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/path", strings.NewReader("request"))
+	assert.NoError(t, err)
+
+	resp, err := cl.Do(req)
+	assert.NoError(t, err)
+
+	data, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, "response", string(data))
+
+	assert.Equal(t, "", req.Header.Get(TraceparentKey), "caller request is not modified")
+
+	tc, err := ReadTraceContext(got)
+	assert.NoError(t, err)
+	assert.Equal(t, root.ID, tc.TraceID)
+	assert.Equal(t, tc.SpanID.StringFull(), got.Get(TraceIDKey))
+
+	type event struct {
+		S      tlog.ID `json:"_s"`
+		P      tlog.ID `json:"_p"`
+		K      string  `json:"_k"`
+		M      string  `json:"_m"`
+		Method string  `json:"method"`
+		URL    string  `json:"url"`
+		Status int     `json:"status_code"`
+		Sent   int     `json:"sent"`
+		Recv   int     `json:"received"`
+		Data   []byte  `json:"data"`
+	}
+
+	var evs []event
+
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var ev event
+
+		err = json.Unmarshal([]byte(line), &ev)
+		assert.NoError(t, err, "%s", line)
+
+		evs = append(evs, ev)
+	}
+
+	if !assert.Equal(t, 5, len(evs), "%s", b) {
+		return
+	}
+
+	assert.Equal(t, event{S: tc.SpanID, P: root.ID, K: "s", M: "http_client_request", Method: "POST", URL: srv.URL + "/path"}, evs[1])
+	assert.Equal(t, event{S: tc.SpanID, M: "request body", Data: []byte("request")}, evs[2])
+	assert.Equal(t, event{S: tc.SpanID, M: "response body", Status: 200, Data: []byte("response")}, evs[3])
+	assert.Equal(t, event{S: tc.SpanID, K: "f", Status: 200, Sent: 7, Recv: 8}, evs[4])
+}
@@ -0,0 +1,523 @@
+// Package tlsql traces database/sql queries.
+//
+// Wrap a driver and open the database as usual.
+// Each query, exec, prepare, and transaction becomes a child span of the context span.
+// Query span is finished when its rows are closed.
+// Calls without a span in the context are passed as is.
+//
+//	db := sql.OpenDB(tlsql.WrapConnector(connector))
+//	// or
+//	sql.Register("traced-pg", tlsql.Wrap(pq.Driver{}))
+//	db, err := sql.Open("traced-pg", dsn)
+package tlsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"time"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+)
+
+type (
+	// Driver wraps driver.Driver and traces its connections.
+	Driver struct {
+		driver.Driver
+
+		Options
+	}
+
+	// Connector wraps driver.Connector and traces its connections.
+	Connector struct {
+		driver.Connector
+
+		Options
+	}
+
+	Options struct {
+		// V is a verbosity topic enabling query args logging.
+		// Nothing is logged if empty.
+		V string
+
+		// Slow is a duration after which the span is finished with tlog.Warn level.
+		// Zero disables it.
+		Slow time.Duration
+	}
+
+	conn struct {
+		driver.Conn
+
+		opts *Options
+	}
+
+	stmt struct {
+		driver.Stmt
+
+		opts  *Options
+		query string
+	}
+
+	tx struct {
+		driver.Tx
+
+		s span
+	}
+
+	// rows finishes the query span on Close.
+	rows struct {
+		driver.Rows
+
+		s   span
+		n   int64
+		err error // Next error
+	}
+
+	// dsnConnector is used for drivers not implementing driver.DriverContext.
+	dsnConnector struct {
+		name string
+		d    driver.Driver
+	}
+
+	// span is a single driver call.
+	span struct {
+		tlog.Span
+
+		opts  *Options
+		start time.Time
+	}
+)
+
+// ArgsTopic is a default Options.V.
+var ArgsTopic = "sql_args"
+
+var (
+	_ driver.DriverContext = &Driver{}
+
+	_ driver.ConnPrepareContext = &conn{}
+	_ driver.ConnBeginTx        = &conn{}
+	_ driver.ExecerContext      = &conn{}
+	_ driver.QueryerContext     = &conn{}
+	_ driver.Pinger             = &conn{}
+	_ driver.SessionResetter    = &conn{}
+	_ driver.Validator          = &conn{}
+	_ driver.NamedValueChecker  = &conn{}
+
+	_ driver.StmtExecContext  = &stmt{}
+	_ driver.StmtQueryContext = &stmt{}
+
+	_ driver.RowsNextResultSet              = &rows{}
+	_ driver.RowsColumnTypeScanType         = &rows{}
+	_ driver.RowsColumnTypeDatabaseTypeName = &rows{}
+	_ driver.RowsColumnTypeLength           = &rows{}
+	_ driver.RowsColumnTypeNullable         = &rows{}
+	_ driver.RowsColumnTypePrecisionScale   = &rows{}
+)
+
+// Wrap wraps d with Driver.
+func Wrap(d driver.Driver) *Driver {
+	return &Driver{
+		Driver:  d,
+		Options: Options{V: ArgsTopic},
+	}
+}
+
+// WrapConnector wraps c with Connector.
+func WrapConnector(c driver.Connector) *Connector {
+	return &Connector{
+		Connector: c,
+		Options:   Options{V: ArgsTopic},
+	}
+}
+
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: c, opts: &d.Options}, nil
+}
+
+// OpenConnector implements driver.DriverContext.
+// Underlying driver Open is used if it doesn't implement it.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	dc, ok := d.Driver.(driver.DriverContext)
+	if !ok {
+		return &Connector{Connector: dsnConnector{name: name, d: d.Driver}, Options: d.Options}, nil
+	}
+
+	c, err := dc.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Connector{Connector: c, Options: d.Options}, nil
+}
+
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cc, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: cc, opts: &c.Options}, nil
+}
+
+func (c *Connector) Driver() driver.Driver {
+	return &Driver{Driver: c.Connector.Driver(), Options: c.Options}
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.d.Open(c.name)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.d
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (_ driver.Stmt, err error) {
+	s := c.opts.spawn(ctx, "sql_prepare", "query", query)
+	defer func() {
+		s.finish(err)
+	}()
+
+	var st driver.Stmt
+
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		st, err = pc.PrepareContext(ctx, query)
+	} else {
+		st, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &stmt{Stmt: st, opts: c.opts, query: query}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (_ driver.Tx, err error) {
+	s := c.opts.spawn(ctx, "sql_tx")
+
+	var t driver.Tx
+
+	if bt, ok := c.Conn.(driver.ConnBeginTx); ok {
+		t, err = bt.BeginTx(ctx, opts)
+	} else if opts != (driver.TxOptions{}) {
+		err = errors.New("driver does not support non-default transaction options")
+	} else {
+		t, err = c.Conn.Begin() //nolint:staticcheck
+	}
+	if err != nil {
+		s.finish(err)
+
+		return nil, err
+	}
+
+	if s.Logger == nil {
+		return t, nil
+	}
+
+	return &tx{Tx: t, s: s}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (res driver.Result, err error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	s := c.opts.spawn(ctx, "sql_exec", "query", query)
+	s.args(args)
+
+	defer func() {
+		s.finishResult(res, err)
+	}()
+
+	return ec.ExecContext(ctx, query, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (_ driver.Rows, err error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	s := c.opts.spawn(ctx, "sql_query", "query", query)
+	s.args(args)
+
+	return s.rows(qc.QueryContext(ctx, query, args))
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	sp := s.opts.spawn(ctx, "sql_exec", "query", s.query)
+	sp.args(args)
+
+	defer func() {
+		sp.finishResult(res, err)
+	}()
+
+	if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return ec.ExecContext(ctx, args)
+	}
+
+	vals, err := values(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Stmt.Exec(vals) //nolint:staticcheck
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (_ driver.Rows, err error) {
+	sp := s.opts.spawn(ctx, "sql_query", "query", s.query)
+	sp.args(args)
+
+	if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return sp.rows(qc.QueryContext(ctx, args))
+	}
+
+	vals, err := values(args)
+	if err != nil {
+		sp.finish(err)
+		return nil, err
+	}
+
+	return sp.rows(s.Stmt.Query(vals)) //nolint:staticcheck
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+func (t *tx) Commit() (err error) {
+	err = t.Tx.Commit()
+
+	t.s.finish(err, "end", "commit")
+
+	return err
+}
+
+func (t *tx) Rollback() (err error) {
+	err = t.Tx.Rollback()
+
+	t.s.finish(err, "end", "rollback")
+
+	return err
+}
+
+func (o *Options) spawn(ctx context.Context, name string, kvs ...interface{}) span {
+	s := tlog.SpawnFromContext(ctx, name, kvs...)
+	if s.Logger == nil {
+		return span{}
+	}
+
+	return span{
+		Span:  s,
+		opts:  o,
+		start: time.Now(),
+	}
+}
+
+func (s span) args(args []driver.NamedValue) {
+	if s.Logger == nil || s.opts.V == "" || !s.If(s.opts.V) || len(args) == 0 {
+		return
+	}
+
+	vals := make([]interface{}, len(args))
+
+	for i, a := range args {
+		if a.Name != "" {
+			vals[i] = sql.Named(a.Name, a.Value)
+		} else {
+			vals[i] = a.Value
+		}
+	}
+
+	s.Printw("args", "args", vals)
+}
+
+// rows wraps query rows to finish the span on Close.
+func (s span) rows(r driver.Rows, err error) (driver.Rows, error) {
+	if err != nil {
+		s.finish(err)
+		return nil, err
+	}
+
+	if s.Logger == nil {
+		return r, nil
+	}
+
+	return &rows{Rows: r, s: s}, nil
+}
+
+func (s span) finishResult(res driver.Result, err error) {
+	if s.Logger == nil {
+		return
+	}
+
+	if err != nil || res == nil {
+		s.finish(err)
+		return
+	}
+
+	n, rerr := res.RowsAffected()
+	if rerr != nil {
+		s.finish(nil)
+		return
+	}
+
+	s.finish(nil, "rows_affected", n)
+}
+
+func (s span) finish(err error, kvs ...interface{}) {
+	if s.Logger == nil {
+		return
+	}
+
+	if err != nil {
+		kvs = append(kvs, "err", err)
+	}
+
+	if s.opts.Slow != 0 && time.Since(s.start) >= s.opts.Slow {
+		kvs = append(kvs, "slow", true, tlog.KeyLogLevel, tlog.Warn)
+	}
+
+	s.Finish(kvs...)
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+
+	switch {
+	case err == nil:
+		r.n++
+	case !errors.Is(err, io.EOF) && r.err == nil:
+		r.err = err
+	}
+
+	return err
+}
+
+func (r *rows) Close() error {
+	err := r.Rows.Close()
+
+	ferr := r.err
+	if ferr == nil {
+		ferr = err
+	}
+
+	r.s.finish(ferr, "rows", r.n)
+
+	return err
+}
+
+func (r *rows) HasNextResultSet() bool {
+	if nr, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return nr.HasNextResultSet()
+	}
+
+	return false
+}
+
+func (r *rows) NextResultSet() error {
+	if nr, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return nr.NextResultSet()
+	}
+
+	return io.EOF
+}
+
+func (r *rows) ColumnTypeScanType(i int) reflect.Type {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return ct.ColumnTypeScanType(i)
+	}
+
+	return reflect.TypeFor[any]()
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(i int) string {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return ct.ColumnTypeDatabaseTypeName(i)
+	}
+
+	return ""
+}
+
+func (r *rows) ColumnTypeLength(i int) (int64, bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return ct.ColumnTypeLength(i)
+	}
+
+	return 0, false
+}
+
+func (r *rows) ColumnTypeNullable(i int) (nullable, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return ct.ColumnTypeNullable(i)
+	}
+
+	return false, false
+}
+
+func (r *rows) ColumnTypePrecisionScale(i int) (precision, scale int64, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ct.ColumnTypePrecisionScale(i)
+	}
+
+	return 0, 0, false
+}
+
+func values(args []driver.NamedValue) ([]driver.Value, error) {
+	vals := make([]driver.Value, len(args))
+
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, errors.New("driver does not support named parameters")
+		}
+
+		vals[i] = a.Value
+	}
+
+	return vals, nil
+}
@@ -0,0 +1,219 @@
+package tlsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/convert"
+)
+
+type (
+	testDriver struct{}
+
+	testConn struct{}
+
+	testStmt struct {
+		query string
+	}
+
+	testTx struct{}
+
+	testRows struct {
+		n    int
+		fail bool
+	}
+
+	testEvent struct {
+		S    tlog.ID       `json:"_s"`
+		P    tlog.ID       `json:"_p"`
+		K    string        `json:"_k"`
+		M    string        `json:"_m"`
+		L    tlog.LogLevel `json:"_l"`
+		Q    string        `json:"query"`
+		Args []interface{} `json:"args"`
+		Rows int           `json:"rows_affected"`
+		N    int           `json:"rows"`
+		End  string        `json:"end"`
+		Slow bool          `json:"slow"`
+		Err  string        `json:"err"`
+	}
+)
+
+func TestDriver(t *testing.T) {
+	var b low.Buf
+
+	l := tlog.New(convert.NewJSON(&b))
+	l.SetVerbosity(ArgsTopic)
+
+	d := Wrap(testDriver{})
+	d.Slow = 10 * time.Millisecond
+
+	c, err := d.OpenConnector("test")
+	assert.NoError(t, err)
+
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	root := l.Start("root")
+	ctx := tlog.ContextWithSpan(context.Background(), root)
+
+	res, err := db.ExecContext(ctx, "update t set x = ?", 5)
+	assert.NoError(t, err)
+
+	n, err := res.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	_, err = db.ExecContext(ctx, "fail")
+	assert.Error(t, err)
+
+	var x int64
+
+	err = db.QueryRowContext(ctx, "select x from t where y = ?", "a").Scan(&x)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), x)
+
+	rows, err := db.QueryContext(ctx, "broken rows")
+	assert.NoError(t, err)
+
+	for rows.Next() {
+	}
+
+	assert.Error(t, rows.Err())
+	assert.NoError(t, rows.Close())
+
+	tx, err := db.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+
+	_, err = tx.ExecContext(ctx, "slow")
+	assert.NoError(t, err)
+
+	err = tx.Commit()
+	assert.NoError(t, err)
+
+	// no span, no tracing
+	_, err = db.ExecContext(context.Background(), "update t set x = ?", 6)
+	assert.NoError(t, err)
+
+	evs := parseEvents(t, b)
+
+	for _, ev := range evs {
+		if ev.K == "s" && ev.M != "root" {
+			assert.Equal(t, root.ID, ev.P, "parent of %v", ev.M)
+		}
+	}
+
+	strip := func(evs []testEvent) []testEvent {
+		for i := range evs {
+			evs[i].S, evs[i].P = tlog.ID{}, tlog.ID{}
+		}
+
+		return evs
+	}
+
+	assert.Equal(t, []testEvent{
+		{K: "s", M: "root"},
+
+		{K: "s", M: "sql_exec", Q: "update t set x = ?"},
+		{M: "args", Args: []interface{}{5.}},
+		{K: "f", Rows: 3},
+
+		{K: "s", M: "sql_exec", Q: "fail"},
+		{K: "f", Err: "test error"},
+
+		{K: "s", M: "sql_prepare", Q: "select x from t where y = ?"},
+		{K: "f"},
+		{K: "s", M: "sql_query", Q: "select x from t where y = ?"},
+		{M: "args", Args: []interface{}{"a"}},
+		{K: "f", N: 1},
+
+		{K: "s", M: "sql_prepare", Q: "broken rows"},
+		{K: "f"},
+		{K: "s", M: "sql_query", Q: "broken rows"},
+		{K: "f", N: 1, Err: "broken row"},
+
+		{K: "s", M: "sql_tx"},
+		{K: "s", M: "sql_exec", Q: "slow"},
+		{K: "f", Rows: 3, Slow: true, L: tlog.Warn},
+		{K: "f", End: "commit", Slow: true, L: tlog.Warn},
+	}, strip(evs))
+}
+
+func (testDriver) Open(name string) (driver.Conn, error) {
+	return testConn{}, nil
+}
+
+func (testConn) Prepare(query string) (driver.Stmt, error) {
+	return testStmt{query: query}, nil
+}
+
+func (testConn) Close() error { return nil }
+
+func (testConn) Begin() (driver.Tx, error) { return testTx{}, nil }
+
+func (testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	switch query {
+	case "fail":
+		return nil, errors.New("test error")
+	case "slow":
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return driver.RowsAffected(3), nil
+}
+
+func (s testStmt) Close() error  { return nil }
+func (s testStmt) NumInput() int { return -1 }
+
+func (s testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (s testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &testRows{fail: s.query == "broken rows"}, nil
+}
+
+func (testTx) Commit() error   { return nil }
+func (testTx) Rollback() error { return nil }
+
+func (r *testRows) Columns() []string { return []string{"x"} }
+func (r *testRows) Close() error      { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.n != 0 && r.fail {
+		return errors.New("broken row")
+	}
+
+	if r.n != 0 {
+		return io.EOF
+	}
+
+	r.n++
+	dest[0] = int64(1)
+
+	return nil
+}
+
+func parseEvents(t *testing.T, b []byte) (evs []testEvent) {
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var ev testEvent
+
+		err := json.Unmarshal([]byte(line), &ev)
+		assert.NoError(t, err, "%s", line)
+
+		evs = append(evs, ev)
+	}
+
+	return evs
+}
@@ -0,0 +1,143 @@
+package tlog
+
+type (
+	// Counter is a monotonic metric. Observed values are increments.
+	Counter struct {
+		metric
+	}
+
+	// Gauge is a metric which can go up and down. Observed values are the current value.
+	Gauge struct {
+		metric
+	}
+
+	// Histogram counts observed values in buckets.
+	Histogram struct {
+		metric
+	}
+
+	metric struct {
+		Span
+
+		name string
+		kvs  []interface{}
+	}
+)
+
+// Metric types.
+const (
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
+)
+
+// Metric event keys.
+// Value events have KeyMetricValue, declaration events have KeyMetricType instead.
+// Metric name is a message.
+// Keys are reserved like the other predefined keys, so any other key is a label.
+var (
+	KeyMetricValue   = "_v"
+	KeyMetricType    = "_mt"
+	KeyMetricHelp    = "_mh"
+	KeyMetricUnit    = "_mu"
+	KeyMetricBuckets = "_mb"
+)
+
+// Observe writes EventMetric event with the metric value.
+// kvs are metric labels.
+func (l *Logger) Observe(name string, v interface{}, kvs ...interface{}) {
+	observe(Span{Logger: l}, name, v, kvs)
+}
+
+// Observe writes EventMetric event with the metric value.
+// Span ID and With kvs are added.
+func (s Span) Observe(name string, v interface{}, kvs ...interface{}) {
+	observe(s, name, v, kvs)
+}
+
+// Metric declares metric metadata.
+// It's written once per name for the Logger, subsequent calls are ignored.
+// kvs are additional metadata, like KeyMetricBuckets for Histogram.
+func (l *Logger) Metric(name, typ, help, unit string, kvs ...interface{}) {
+	if l == nil {
+		return
+	}
+
+	if _, loaded := l.metrics.LoadOrStore(name, struct{}{}); loaded {
+		return
+	}
+
+	meta := make([]interface{}, 0, 8+len(kvs))
+	meta = append(meta, "", EventMetric, KeyMetricType, typ)
+
+	if help != "" {
+		meta = append(meta, KeyMetricHelp, help)
+	}
+
+	if unit != "" {
+		meta = append(meta, KeyMetricUnit, unit)
+	}
+
+	meta = append(meta, kvs...)
+
+	message(l, ID{}, "", -1, name, meta)
+}
+
+// NewCounter declares a counter and returns a helper observing it with kvs labels.
+func (l *Logger) NewCounter(name, help string, kvs ...interface{}) Counter {
+	l.Metric(name, MetricCounter, help, "")
+
+	return Counter{metric{Span: Span{Logger: l}, name: name, kvs: kvs}}
+}
+
+// NewGauge declares a gauge and returns a helper observing it with kvs labels.
+func (l *Logger) NewGauge(name, help string, kvs ...interface{}) Gauge {
+	l.Metric(name, MetricGauge, help, "")
+
+	return Gauge{metric{Span: Span{Logger: l}, name: name, kvs: kvs}}
+}
+
+// NewHistogram declares a histogram and returns a helper observing it with kvs labels.
+// Default buckets are used by aggregator if buckets are nil.
+func (l *Logger) NewHistogram(name, help string, buckets []float64, kvs ...interface{}) Histogram {
+	var meta []interface{}
+	if buckets != nil {
+		meta = []interface{}{KeyMetricBuckets, buckets}
+	}
+
+	l.Metric(name, MetricHistogram, help, "", meta...)
+
+	return Histogram{metric{Span: Span{Logger: l}, name: name, kvs: kvs}}
+}
+
+// Add increments the counter by v.
+func (c Counter) Add(v interface{}) {
+	observe(c.Span, c.name, v, c.kvs)
+}
+
+// Inc increments the counter by one.
+func (c Counter) Inc() {
+	observe(c.Span, c.name, 1, c.kvs)
+}
+
+// Set sets the gauge value.
+func (g Gauge) Set(v interface{}) {
+	observe(g.Span, g.name, v, g.kvs)
+}
+
+// Observe records a value in the histogram.
+func (h Histogram) Observe(v interface{}) {
+	observe(h.Span, h.name, v, h.kvs)
+}
+
+func observe(s Span, name string, v interface{}, kvs []interface{}) {
+	if s.Logger == nil {
+		return
+	}
+
+	ev := make([]interface{}, 0, 4+len(kvs))
+	ev = append(ev, "", EventMetric, KeyMetricValue, v)
+	ev = append(ev, kvs...)
+
+	message(s.Logger, s.ID, s.ctx, -1, name, ev)
+}
@@ -0,0 +1,47 @@
+package tlog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+)
+
+func TestMetrics(t *testing.T) {
+	var buf low.Buf
+
+	l := New(NewConsoleWriter(&buf, 0))
+	LoggerSetTimeNow(l, nil, nil)
+
+	c := l.NewCounter("requests", "Number of requests", "method", "GET")
+	c.Inc()
+	c.Add(2)
+
+	l.NewCounter("requests", "declared once")
+
+	g := l.NewGauge("queue", "")
+	g.Set(5)
+
+	h := l.NewHistogram("latency", "", []float64{0.1, 1})
+	h.Observe(0.5)
+
+	l.With("svc", "api").Observe("untyped", -1, "v", "label")
+
+	var lines []string
+
+	for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+
+	assert.Equal(t, []string{
+		`requests _k=m _mt=counter _mh="Number of requests"`,
+		"requests _k=m _v=1 method=GET",
+		"requests _k=m _v=2 method=GET",
+		"queue _k=m _mt=gauge",
+		"queue _k=m _v=5",
+		"latency _k=m _mt=histogram _mb=[0.10000 1.00000]",
+		"latency _k=m _v=0.50000",
+		"untyped _k=m _v= -1 v=label svc=api",
+	}, lines)
+}
@@ -0,0 +1,113 @@
+package tlog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"nikand.dev/go/hacked/htime"
+	"tlog.app/go/loc"
+)
+
+type (
+	// sampler limits messages per call site.
+	// The first events in each interval are passed, then only every nth.
+	sampler struct {
+		first, every int64
+		interval     int64
+
+		mu sync.RWMutex           `deep:"-"`
+		c  map[loc.PC]*sampleSite `deep:"-"`
+	}
+
+	sampleSite struct {
+		mu sync.Mutex
+
+		start      int64
+		n          int64
+		suppressed int64
+	}
+)
+
+func SetSampling(first, every int, interval time.Duration) {
+	DefaultLogger.SetSampling(first, every, interval)
+}
+
+// SetSampling limits messages logged from each call site.
+// The first events in each interval are logged, then only each every-th of the rest.
+// every == 0 means the rest are dropped.
+// Zero interval means the limits are never reset.
+// Sampling is disabled if both first and every are zero.
+//
+// The next logged message from a call site has KeyRepeated with the number of suppressed ones.
+// Span start and finish events and messages logged with negative depth,
+// such as metrics, are never sampled.
+// Call sites are sampled even if the Logger doesn't record callers.
+func (l *Logger) SetSampling(first, every int, interval time.Duration) {
+	var s *sampler
+
+	if first != 0 || every != 0 {
+		s = &sampler{
+			first:    int64(first),
+			every:    int64(every),
+			interval: interval.Nanoseconds(),
+			c:        make(map[loc.PC]*sampleSite),
+		}
+	}
+
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&l.sampler)), unsafe.Pointer(s))
+}
+
+func (l *Logger) getsampler() *sampler {
+	return (*sampler)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&l.sampler))))
+}
+
+// sample reports whether the event must be logged
+// and how many events were suppressed before it.
+func (s *sampler) sample(pc loc.PC, now int64) (ok bool, suppressed int64) {
+	s.mu.RLock()
+	c := s.c[pc]
+	s.mu.RUnlock()
+
+	if c == nil {
+		s.mu.Lock()
+
+		c = s.c[pc]
+		if c == nil {
+			c = &sampleSite{start: now}
+			s.c[pc] = c
+		}
+
+		s.mu.Unlock()
+	}
+
+	defer c.mu.Unlock()
+	c.mu.Lock()
+
+	if s.interval != 0 && now-c.start >= s.interval {
+		c.start = now
+		c.n = 0
+	}
+
+	c.n++
+
+	if c.n > s.first && (s.every == 0 || (c.n-s.first)%s.every != 0) {
+		c.suppressed++
+
+		return false, 0
+	}
+
+	suppressed = c.suppressed
+	c.suppressed = 0
+
+	return true, suppressed
+}
+
+func nanotime(l *Logger) int64 {
+	if l.nano != nil {
+		return l.nano()
+	}
+
+	return htime.UnixNano()
+}
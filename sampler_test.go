@@ -0,0 +1,81 @@
+package tlog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+)
+
+func TestSampling(t *testing.T) {
+	var buf low.Buf
+	var now int64
+
+	l := New(NewConsoleWriter(&buf, 0))
+	LoggerSetTimeNow(l, func() time.Time { return time.Unix(0, now) }, func() int64 { return now })
+
+	l.SetSampling(2, 3, time.Second)
+
+	for i := range 13 {
+		if i == 10 {
+			now += time.Second.Nanoseconds()
+		}
+
+		l.Printw("loop", "i", i)
+
+		if i < 10 {
+			l.Printw("other", "i", i)
+		}
+	}
+
+	var lines, other []string
+
+	for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+
+		if strings.HasPrefix(line, "loop") {
+			lines = append(lines, line)
+		} else {
+			other = append(other, line)
+		}
+	}
+
+	assert.Equal(t, []string{
+		"loop i=0",
+		"loop i=1",
+		"loop (repeated 2 times) i=4",
+		"loop (repeated 2 times) i=7",
+		"loop (repeated 2 times) i=10", // new interval
+		"loop i=11",
+	}, lines)
+
+	assert.Equal(t, []string{
+		"other i=0",
+		"other i=1",
+		"other (repeated 2 times) i=4",
+		"other (repeated 2 times) i=7",
+	}, other)
+
+	buf = buf[:0]
+
+	l.SetSampling(0, 0, 0)
+
+	for range 5 {
+		l.Printw("loop")
+	}
+
+	assert.Equal(t, 5, strings.Count(string(buf), "loop"))
+
+	buf = buf[:0]
+
+	LoggerSetCallers(l, 0, nil)
+	l.SetSampling(1, 0, 0)
+
+	for range 5 {
+		l.Printw("no callers")
+	}
+
+	assert.Equal(t, 1, strings.Count(string(buf), "no callers"))
+}
@@ -0,0 +1,330 @@
+package tlio
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// AsyncWriter writes events to the underlying Writer in a background goroutine,
+	// so a slow Writer doesn't block the Logger and all goroutines using it.
+	//
+	// Events are copied into a bounded queue.
+	// Policy defines what happens when the queue is full.
+	// Dropped events are counted and reported as a Warn event
+	// every ReportInterval and on Close.
+	//
+	// Zero value AsyncWriter with Writer set has a queue of one event.
+	// Fields must not be changed after the first Write.
+	// Flush or Close must be called on shutdown to drain the queue.
+	AsyncWriter struct {
+		io.Writer
+
+		Policy OverflowPolicy
+
+		// ReportInterval is how often dropped events are reported.
+		// Zero disables periodic reports.
+		ReportInterval time.Duration
+
+		once sync.Once
+		stop chan struct{}
+		done chan struct{}
+
+		wmu sync.Mutex // underlying Writer
+
+		mu   sync.Mutex
+		cond sync.Cond
+
+		q    [][]byte // ring buffer
+		r, n int
+
+		free [][]byte
+
+		writing bool
+		closed  bool
+		report  bool
+
+		dropped, reported int64
+
+		err error
+	}
+
+	// OverflowPolicy defines AsyncWriter behaviour when its queue is full.
+	OverflowPolicy int
+)
+
+// Overflow policies.
+const (
+	// OverflowBlock blocks Write until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest drops the event being written.
+	OverflowDropNewest
+
+	// OverflowDropOldest drops the oldest queued event to make room for the new one.
+	OverflowDropOldest
+)
+
+var ErrClosed = errors.New("writer closed")
+
+// NewAsyncWriter creates AsyncWriter with a queue of n events.
+func NewAsyncWriter(w io.Writer, n int, policy OverflowPolicy) *AsyncWriter {
+	if n <= 0 {
+		n = 1
+	}
+
+	return &AsyncWriter{
+		Writer:         w,
+		Policy:         policy,
+		ReportInterval: 10 * time.Second,
+
+		q: make([][]byte, n),
+	}
+}
+
+// Write queues a copy of p.
+// It never returns the underlying Writer errors, they are returned by Flush and Close.
+func (w *AsyncWriter) Write(p []byte) (n int, err error) {
+	w.once.Do(w.start)
+
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	for w.n == len(w.q) && w.Policy == OverflowBlock && !w.closed {
+		w.cond.Wait()
+	}
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	var b []byte
+
+	switch {
+	case w.n < len(w.q):
+		if l := len(w.free); l != 0 {
+			b = w.free[l-1]
+			w.free = w.free[:l-1]
+		}
+	case w.Policy == OverflowDropOldest:
+		b = w.q[w.r]
+		w.q[w.r] = nil
+		w.r = (w.r + 1) % len(w.q)
+		w.n--
+
+		w.dropped++
+	default:
+		w.dropped++
+
+		return len(p), nil
+	}
+
+	w.q[(w.r+w.n)%len(w.q)] = append(b[:0], p...)
+	w.n++
+
+	w.cond.Broadcast()
+
+	return len(p), nil
+}
+
+// Flush waits for the queue to be written and flushes the underlying Writer if it's a Flusher.
+func (w *AsyncWriter) Flush() (err error) {
+	w.once.Do(w.start)
+
+	w.mu.Lock()
+
+	for w.n != 0 || w.writing || w.report {
+		w.cond.Wait()
+	}
+
+	err = w.err
+	w.err = nil
+
+	w.mu.Unlock()
+
+	if f, ok := w.Writer.(Flusher); ok {
+		w.wmu.Lock()
+		e := f.Flush()
+		w.wmu.Unlock()
+
+		if err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Close drains the queue, reports dropped events, and closes the underlying Writer if it's a Closer.
+// Writes after Close return ErrClosed.
+func (w *AsyncWriter) Close() (err error) {
+	w.once.Do(w.start)
+
+	w.mu.Lock()
+
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+
+	w.closed = true
+	w.report = w.dropped != w.reported
+	w.cond.Broadcast()
+
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+
+	err = w.err
+
+	if c, ok := w.Writer.(io.Closer); ok {
+		e := c.Close()
+		if err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Dropped returns the number of dropped events.
+func (w *AsyncWriter) Dropped() int64 {
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	return w.dropped
+}
+
+func (w *AsyncWriter) Unwrap() interface{} {
+	return w.Writer
+}
+
+func (w *AsyncWriter) start() {
+	w.cond.L = &w.mu
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+
+	if len(w.q) == 0 {
+		w.q = make([][]byte, 1)
+	}
+
+	go w.run()
+
+	if w.ReportInterval > 0 {
+		go w.ticker()
+	}
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	for {
+		for w.n == 0 && !w.report && !w.closed {
+			w.cond.Wait()
+		}
+
+		switch {
+		case w.report && (w.n == 0 || !w.closed): // on close report after the queue is drained
+			d := w.dropped - w.reported
+			w.reported = w.dropped
+			w.writing = true
+
+			w.mu.Unlock()
+
+			err := w.writeReport(d)
+
+			w.mu.Lock()
+
+			w.report = false
+			w.setErr(err)
+		case w.n != 0:
+			b := w.q[w.r]
+			w.q[w.r] = nil
+			w.r = (w.r + 1) % len(w.q)
+			w.n--
+			w.writing = true
+
+			w.mu.Unlock()
+
+			w.wmu.Lock()
+			_, err := w.Writer.Write(b)
+			w.wmu.Unlock()
+
+			w.mu.Lock()
+
+			w.free = append(w.free, b)
+			w.setErr(err)
+		default: // closed and drained
+			return
+		}
+
+		w.writing = false
+		w.cond.Broadcast()
+	}
+}
+
+func (w *AsyncWriter) ticker() {
+	t := time.NewTicker(w.ReportInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-w.stop:
+			return
+		}
+
+		w.mu.Lock()
+
+		if w.dropped != w.reported {
+			w.report = true
+			w.cond.Broadcast()
+		}
+
+		w.mu.Unlock()
+	}
+}
+
+func (w *AsyncWriter) writeReport(dropped int64) error {
+	if dropped == 0 {
+		return nil
+	}
+
+	var e tlwire.Encoder
+
+	b := e.AppendMap(nil, -1)
+
+	b = e.AppendString(b, tlog.KeyTimestamp)
+	b = e.AppendTimestamp(b, time.Now().UnixNano())
+
+	b = tlog.AppendKVs(&e, b, []interface{}{
+		tlog.KeyMessage, tlog.NextAsMessage, "async writer dropped events",
+		tlog.KeyLogLevel, tlog.Warn,
+		"dropped", dropped,
+	})
+
+	b = e.AppendBreak(b)
+
+	defer w.wmu.Unlock()
+	w.wmu.Lock()
+
+	_, err := w.Writer.Write(b)
+
+	return err
+}
+
+func (w *AsyncWriter) setErr(err error) {
+	if w.err == nil && err != nil {
+		w.err = err
+	}
+}
@@ -0,0 +1,190 @@
+package tlio
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+)
+
+type blockingWriter struct {
+	mu sync.Mutex
+	b  low.Buf
+
+	entered chan struct{}
+	release chan struct{}
+}
+
+func TestAsyncWriterPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy OverflowPolicy
+		exp    string
+		drop   int64
+	}{
+		{"block", OverflowBlock, "e1e2e3e4", 0},
+		{"drop_newest", OverflowDropNewest, "e1e2e3", 1},
+		{"drop_oldest", OverflowDropOldest, "e1e3e4", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bw := newBlockingWriter()
+
+			w := NewAsyncWriter(bw, 2, tc.policy)
+			w.ReportInterval = 0
+
+			_, err := w.Write([]byte("e1"))
+			assert.NoError(t, err)
+
+			<-bw.entered // e1 is being written, the queue is empty
+
+			for _, e := range []string{"e2", "e3"} {
+				_, err = w.Write([]byte(e))
+				assert.NoError(t, err)
+			}
+
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+
+				_, err := w.Write([]byte("e4"))
+				assert.NoError(t, err)
+			}()
+
+			if tc.policy == OverflowBlock {
+				select {
+				case <-done:
+					t.Errorf("write is not blocked")
+				case <-time.After(10 * time.Millisecond):
+				}
+			} else {
+				<-done
+			}
+
+			close(bw.release)
+			<-done
+
+			err = w.Close()
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.drop, w.Dropped())
+
+			data := bw.Bytes()
+
+			assert.True(t, bytes.HasPrefix(data, []byte(tc.exp)), "%q", data)
+			assert.Equal(t, tc.drop != 0, bytes.Contains(data, []byte("async writer dropped events")), "%q", data)
+
+			_, err = w.Write([]byte("e5"))
+			assert.ErrorIs(t, err, ErrClosed)
+		})
+	}
+}
+
+func TestAsyncWriterFlush(t *testing.T) {
+	var b low.Buf
+
+	w := NewAsyncWriter(&b, 4, OverflowBlock)
+
+	for _, e := range []string{"a", "b", "c", "d", "e", "f"} {
+		_, err := w.Write([]byte(e))
+		assert.NoError(t, err)
+	}
+
+	err := w.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef", string(b))
+
+	err = w.Close()
+	assert.NoError(t, err)
+}
+
+func TestAsyncWriterZero(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropNewest, OverflowDropOldest} {
+		var b low.Buf
+
+		w := &AsyncWriter{Writer: &b, Policy: policy}
+
+		for _, e := range []string{"a", "b", "c"} {
+			_, err := w.Write([]byte(e))
+			assert.NoError(t, err)
+		}
+
+		err := w.Close()
+		assert.NoError(t, err)
+
+		if policy == OverflowBlock {
+			assert.Equal(t, "abc", string(b))
+		}
+	}
+}
+
+func TestAsyncWriterReport(t *testing.T) {
+	bw := newBlockingWriter()
+
+	w := NewAsyncWriter(bw, 1, OverflowDropNewest)
+	w.ReportInterval = time.Millisecond
+
+	_, _ = w.Write([]byte("e1"))
+	<-bw.entered
+
+	_, _ = w.Write([]byte("e2"))
+	_, _ = w.Write([]byte("e3"))
+
+	close(bw.release)
+
+	for range 100 {
+		bw.mu.Lock()
+		ok := bytes.Contains(bw.b, []byte("async writer dropped events"))
+		bw.mu.Unlock()
+
+		if ok {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	err := w.Flush()
+	assert.NoError(t, err)
+
+	data := bw.Bytes()
+
+	assert.True(t, bytes.HasPrefix(data, []byte("e1e2")), "%q", data)
+	assert.True(t, bytes.Contains(data, []byte("async writer dropped events")), "%q", data)
+
+	err = w.Close()
+	assert.NoError(t, err)
+
+	assert.Equal(t, data, bw.Bytes(), "nothing to report on close")
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{
+		entered: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case w.entered <- struct{}{}:
+	default:
+	}
+
+	<-w.release
+
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	return w.b.Write(p)
+}
+
+func (w *blockingWriter) Bytes() []byte {
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	return append([]byte{}, w.b...)
+}
@@ -0,0 +1,243 @@
+package tlio
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// Dedup collapses consecutive identical events.
+	//
+	// Events are identical if they are equal except for the timestamp.
+	// The first event of a run is written as is,
+	// the repeated ones are counted and written as a single event
+	// when the run ends, Timeout passes, or on Flush and Close.
+	// That event is the last repeated one with tlog.KeyRepeated set to the number of collapsed events
+	// and tlog.KeyFirstTimestamp set to the timestamp of the first of them.
+	Dedup struct {
+		io.Writer
+
+		// Timeout is the max time repeated events are held.
+		// Zero means they are held until the run ends.
+		Timeout time.Duration
+
+		d tlwire.Decoder
+		e tlwire.Encoder
+
+		mu sync.Mutex
+
+		key, next []byte // event without timestamp
+		last      []byte // last repeated event
+		firstTS   []byte // first repeated event timestamp
+		n         int
+
+		timer *time.Timer
+		gen   int
+
+		b []byte
+	}
+)
+
+// NewDedup creates Dedup with 10 seconds Timeout.
+func NewDedup(w io.Writer) *Dedup {
+	return &Dedup{
+		Writer:  w,
+		Timeout: 10 * time.Second,
+	}
+}
+
+func (w *Dedup) Write(p []byte) (n int, err error) {
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	var ts []byte
+	var ok bool
+
+	w.next, ts, ok = w.normalize(w.next[:0], p)
+
+	if ok && w.key != nil && bytes.Equal(w.key, w.next) {
+		if w.n == 0 {
+			w.firstTS = append(w.firstTS[:0], ts...)
+			w.startTimer()
+		}
+
+		w.n++
+		w.last = append(w.last[:0], p...)
+
+		return len(p), nil
+	}
+
+	err = w.flushRun()
+
+	if ok {
+		w.key, w.next = w.next, w.key
+	} else {
+		w.key = nil
+	}
+
+	n, err1 := w.Writer.Write(p)
+	if err == nil {
+		err = err1
+	}
+
+	return n, err
+}
+
+// Flush writes pending repeated events and flushes the underlying Writer if it's a Flusher.
+func (w *Dedup) Flush() (err error) {
+	w.mu.Lock()
+	err = w.flushRun()
+	w.mu.Unlock()
+
+	if f, ok := w.Writer.(Flusher); ok {
+		e := f.Flush()
+		if err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Close writes pending repeated events and closes the underlying Writer if it's a Closer.
+func (w *Dedup) Close() (err error) {
+	w.mu.Lock()
+	err = w.flushRun()
+	w.key = nil
+	w.mu.Unlock()
+
+	e := Close(w.Writer)
+	if err == nil {
+		err = e
+	}
+
+	return err
+}
+
+func (w *Dedup) Unwrap() interface{} {
+	return w.Writer
+}
+
+// normalize appends the event without its timestamp to b.
+// It's not ok if p is not a single valid event.
+func (w *Dedup) normalize(b, p []byte) (_, ts []byte, ok bool) {
+	end, err := tlwire.ValueEnd(p, 0)
+	if err != nil || end != len(p) {
+		return b, nil, false
+	}
+
+	tag, els, i := w.d.Tag(p, 0)
+	if tag != tlwire.Map {
+		return b, nil, false
+	}
+
+	for el := 0; els == -1 || el < int(els); el++ {
+		if els == -1 && w.d.Break(p, &i) {
+			break
+		}
+
+		kst := i
+
+		if tag := w.d.TagOnly(p, i); tag != tlwire.String && tag != tlwire.Bytes {
+			return b, nil, false
+		}
+
+		var k []byte
+		k, i = w.d.Bytes(p, i)
+
+		vst := i
+		i = w.d.Skip(p, i)
+
+		if string(k) == tlog.KeyTimestamp {
+			ts = p[vst:i]
+			continue
+		}
+
+		b = append(b, p[kst:i]...)
+	}
+
+	return b, ts, true
+}
+
+func (w *Dedup) flushRun() (err error) {
+	if w.n == 0 {
+		return nil
+	}
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.gen++
+	}
+
+	p := w.last
+
+	_, els, i := w.d.Tag(p, 0)
+
+	b := w.e.AppendMap(w.b[:0], -1)
+	added := false
+
+	for el := 0; els == -1 || el < int(els); el++ {
+		if els == -1 && w.d.Break(p, &i) {
+			break
+		}
+
+		st := i
+
+		var k []byte
+		k, i = w.d.Bytes(p, i)
+		i = w.d.Skip(p, i)
+
+		b = append(b, p[st:i]...)
+
+		if string(k) == tlog.KeyTimestamp {
+			b = w.appendRepeated(b)
+			added = true
+		}
+	}
+
+	if !added {
+		b = w.appendRepeated(b)
+	}
+
+	b = w.e.AppendBreak(b)
+
+	w.b = b[:0]
+	w.n = 0
+
+	_, err = w.Writer.Write(b)
+
+	return err
+}
+
+func (w *Dedup) appendRepeated(b []byte) []byte {
+	if len(w.firstTS) != 0 {
+		b = w.e.AppendString(b, tlog.KeyFirstTimestamp)
+		b = append(b, w.firstTS...)
+	}
+
+	return w.e.AppendKeyInt64(b, tlog.KeyRepeated, int64(w.n))
+}
+
+func (w *Dedup) startTimer() {
+	if w.Timeout <= 0 {
+		return
+	}
+
+	gen := w.gen
+
+	w.timer = time.AfterFunc(w.Timeout, func() {
+		defer w.mu.Unlock()
+		w.mu.Lock()
+
+		if w.gen != gen {
+			return
+		}
+
+		_ = w.flushRun()
+	})
+}
@@ -0,0 +1,122 @@
+package tlio
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+func TestDedup(t *testing.T) {
+	var b low.Buf
+
+	w := NewDedup(tlog.NewConsoleWriter(&b, 0))
+	w.Timeout = 0
+
+	l := tlog.New(w)
+	tlog.LoggerSetTimeNow(l, nil, nil)
+
+	for i := range 8 {
+		switch i {
+		case 4:
+			l.Printw("reconnect", "err", "timeout")
+			continue
+		case 7:
+			err := w.Flush()
+			assert.NoError(t, err)
+		}
+
+		if i < 4 {
+			l.Printw("reconnect", "err", "refused")
+		} else {
+			l.Printw("connected")
+		}
+	}
+
+	err := w.Close()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"reconnect err=refused",
+		"reconnect (repeated 3 times) err=refused",
+		"reconnect err=timeout",
+		"connected",
+		"connected (repeated 1 times)",
+		"connected (repeated 1 times)",
+	}, lines(b))
+}
+
+func TestDedupTimeout(t *testing.T) {
+	var b low.Buf
+
+	w := NewDedup(&b)
+	w.Timeout = time.Millisecond
+
+	l := tlog.New(w)
+	tlog.LoggerSetTimeNow(l, nil, nil)
+
+	for range 3 {
+		l.Printw("event")
+	}
+
+	for range 100 {
+		w.mu.Lock()
+		n := w.n
+		w.mu.Unlock()
+
+		if n == 0 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	assert.Equal(t, 0, w.n)
+	assert.True(t, strings.Contains(tlwire.Dump(b), tlog.KeyRepeated), "%s", tlwire.Dump(b))
+}
+
+func TestDedupMalformed(t *testing.T) {
+	var b low.Buf
+
+	w := NewDedup(&b)
+	w.Timeout = 0
+
+	var e tlwire.Encoder
+
+	ev := e.AppendMap(nil, 1)
+	ev = e.AppendString(ev, "key")
+	ev = e.AppendString(ev, "value")
+
+	for _, p := range [][]byte{
+		ev[:len(ev)-2], // truncated
+		ev[:len(ev)-2],
+		e.AppendString(nil, "not a map"),
+		e.AppendString(nil, "not a map"),
+		e.AppendInt(e.AppendInt(e.AppendMap(nil, 1), 1), 2), // int key
+		e.AppendInt(e.AppendInt(e.AppendMap(nil, 1), 1), 2),
+	} {
+		n, err := w.Write(p)
+		assert.NoError(t, err)
+		assert.Equal(t, len(p), n)
+		assert.Equal(t, 0, w.n, "not deduplicated")
+	}
+
+	err := w.Close()
+	assert.NoError(t, err)
+}
+
+func lines(b []byte) (r []string) {
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		r = append(r, strings.Join(strings.Fields(l), " "))
+	}
+
+	return r
+}
@@ -0,0 +1,505 @@
+package tlio
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type (
+	// Prometheus aggregates tlog.EventMetric events in memory
+	// and serves them in Prometheus text exposition format.
+	// Other events are ignored.
+	//
+	// Counter values are summed up, histogram values are counted in buckets,
+	// the last value is kept for gauges and metrics of unknown type.
+	// Metric labels and Logger labels become Prometheus labels.
+	Prometheus struct {
+		d tlwire.Decoder
+
+		mu   sync.Mutex
+		fams map[string]*promFamily
+
+		ls []promLabel
+		b  []byte
+	}
+
+	promFamily struct {
+		name string
+		typ  string
+		help string
+		unit string
+
+		buckets []float64
+
+		series map[string]*promSeries
+	}
+
+	promSeries struct {
+		labels string
+
+		val float64
+
+		count   uint64
+		sum     float64
+		buckets []uint64
+	}
+
+	promLabel struct {
+		k, v string
+	}
+)
+
+// DefaultBuckets are histogram buckets used if none declared.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		fams: make(map[string]*promFamily),
+	}
+}
+
+func (w *Prometheus) Write(p []byte) (i int, err error) {
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	for i < len(p) {
+		i, err = w.writeEvent(p, i)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *Prometheus) writeEvent(p []byte, st int) (i int, err error) {
+	tag, els, i := w.d.Tag(p, st)
+	if tag != tlwire.Map {
+		return st, errors.New("map expected")
+	}
+
+	var kind tlog.EventKind
+	var name []byte
+	var typ, help, unit string
+	var buckets []float64
+	var val float64
+	var hasVal bool
+
+	w.ls = w.ls[:0]
+
+	for el := 0; els == -1 || el < int(els); el++ {
+		if els == -1 && w.d.Break(p, &i) {
+			break
+		}
+
+		var k []byte
+		k, i = w.d.Bytes(p, i)
+
+		vst := i
+		tag, sub, vi := w.d.Tag(p, i)
+
+		switch {
+		case tag == tlwire.Semantic && sub == tlog.WireEventKind && string(k) == tlog.KeyEventKind:
+			i = kind.TlogParse(p, vst)
+		case tag == tlwire.Semantic && sub == tlog.WireMessage && string(k) == tlog.KeyMessage:
+			name, i = w.d.Bytes(p, vi)
+		case tag == tlwire.Semantic && sub == tlog.WireLabel:
+			i = w.appendLabel(k, p, vi)
+		case string(k) == tlog.KeyMetricValue:
+			val, hasVal, i = w.number(p, vst)
+		case string(k) == tlog.KeyMetricType:
+			typ, i = w.string(p, vst)
+		case string(k) == tlog.KeyMetricHelp:
+			help, i = w.string(p, vst)
+		case string(k) == tlog.KeyMetricUnit:
+			unit, i = w.string(p, vst)
+		case string(k) == tlog.KeyMetricBuckets && tag == tlwire.Array:
+			buckets, i = w.buckets(p, vst)
+		case len(k) != 0 && k[0] == '_':
+			i = w.d.Skip(p, vst)
+		default:
+			i = w.appendLabel(k, p, vst)
+		}
+	}
+
+	if kind != tlog.EventMetric || len(name) == 0 {
+		return i, nil
+	}
+
+	fname := promName(string(name), true)
+
+	f := w.fams[fname]
+	if f == nil {
+		f = &promFamily{
+			name:   fname,
+			series: make(map[string]*promSeries),
+		}
+
+		w.fams[fname] = f
+	}
+
+	if typ != "" {
+		f.typ = typ
+		f.help = help
+		f.unit = unit
+		f.buckets = buckets
+	}
+
+	if !hasVal {
+		return i, nil
+	}
+
+	ls := w.labels()
+
+	s := f.series[ls]
+	if s == nil {
+		s = &promSeries{labels: ls}
+		f.series[ls] = s
+	}
+
+	switch f.typ {
+	case tlog.MetricCounter:
+		s.val += val
+	case tlog.MetricHistogram:
+		bs := f.bucketsOrDefault()
+
+		if len(s.buckets) != len(bs) {
+			s.buckets = make([]uint64, len(bs))
+		}
+
+		for j, le := range bs {
+			if val <= le {
+				s.buckets[j]++
+				break
+			}
+		}
+
+		s.count++
+		s.sum += val
+	default:
+		s.val = val
+	}
+
+	return i, nil
+}
+
+// WriteTo writes metrics in Prometheus text exposition format.
+func (w *Prometheus) WriteTo(wr io.Writer) (n int64, err error) {
+	w.mu.Lock()
+
+	b := w.b[:0]
+
+	names := make([]string, 0, len(w.fams))
+	for name := range w.fams {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := w.fams[name]
+		if len(f.series) == 0 {
+			continue
+		}
+
+		b = f.appendText(b)
+	}
+
+	w.b = b[:0]
+
+	w.mu.Unlock()
+
+	m, err := wr.Write(b)
+
+	return int64(m), err
+}
+
+func (w *Prometheus) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, _ = w.WriteTo(rw)
+}
+
+func (f *promFamily) appendText(b []byte) []byte {
+	typ := f.typ
+	switch typ {
+	case tlog.MetricCounter, tlog.MetricGauge, tlog.MetricHistogram:
+	default:
+		typ = "untyped"
+	}
+
+	if f.help != "" {
+		b = append(b, "# HELP "...)
+		b = append(b, f.name...)
+		b = append(b, ' ')
+		b = appendEscaped(b, f.help, false)
+		b = append(b, '\n')
+	}
+
+	if f.unit != "" {
+		b = append(b, "# UNIT "...)
+		b = append(b, f.name...)
+		b = append(b, ' ')
+		b = appendEscaped(b, f.unit, false)
+		b = append(b, '\n')
+	}
+
+	b = append(b, "# TYPE "...)
+	b = append(b, f.name...)
+	b = append(b, ' ')
+	b = append(b, typ...)
+	b = append(b, '\n')
+
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := f.series[k]
+
+		if typ != tlog.MetricHistogram {
+			b = appendSample(b, f.name, "", s.labels, "", s.val)
+			continue
+		}
+
+		var cum uint64
+
+		for j, le := range f.bucketsOrDefault() {
+			if j < len(s.buckets) {
+				cum += s.buckets[j]
+			}
+
+			b = appendSample(b, f.name, "_bucket", s.labels, formatFloat(le), float64(cum))
+		}
+
+		b = appendSample(b, f.name, "_bucket", s.labels, "+Inf", float64(s.count))
+		b = appendSample(b, f.name, "_sum", s.labels, "", s.sum)
+		b = appendSample(b, f.name, "_count", s.labels, "", float64(s.count))
+	}
+
+	return b
+}
+
+func (f *promFamily) bucketsOrDefault() []float64 {
+	if f.buckets != nil {
+		return f.buckets
+	}
+
+	return DefaultBuckets
+}
+
+func appendSample(b []byte, name, suffix, labels, le string, v float64) []byte {
+	b = append(b, name...)
+	b = append(b, suffix...)
+
+	if labels != "" || le != "" {
+		b = append(b, '{')
+		b = append(b, labels...)
+
+		if le != "" {
+			if labels != "" {
+				b = append(b, ',')
+			}
+
+			b = append(b, `le="`...)
+			b = append(b, le...)
+			b = append(b, '"')
+		}
+
+		b = append(b, '}')
+	}
+
+	b = append(b, ' ')
+	b = append(b, formatFloat(v)...)
+	b = append(b, '\n')
+
+	return b
+}
+
+// labels returns sorted labels formatted as k="v",k2="v2".
+func (w *Prometheus) labels() string {
+	if len(w.ls) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(w.ls, func(i, j int) bool { return w.ls[i].k < w.ls[j].k })
+
+	var b []byte
+
+	for j, l := range w.ls {
+		if j != 0 {
+			b = append(b, ',')
+		}
+
+		b = append(b, l.k...)
+		b = append(b, '=', '"')
+		b = appendEscaped(b, l.v, true)
+		b = append(b, '"')
+	}
+
+	return string(b)
+}
+
+func (w *Prometheus) appendLabel(k, p []byte, st int) (i int) {
+	var v string
+
+	tag, sub, i := w.d.Tag(p, st)
+
+	switch tag {
+	case tlwire.String, tlwire.Bytes:
+		var s []byte
+		s, i = w.d.Bytes(p, st)
+		v = string(s)
+	case tlwire.Int, tlwire.Neg:
+		var x int64
+		x, i = w.d.Signed(p, st)
+		v = strconv.FormatInt(x, 10)
+	case tlwire.Special:
+		switch sub {
+		case tlwire.False:
+			v = "false"
+		case tlwire.True:
+			v = "true"
+		case tlwire.Float64, tlwire.Float32, tlwire.Float16, tlwire.Float8:
+			var f float64
+			f, i = w.d.Float(p, st)
+			v = formatFloat(f)
+		default:
+			return w.d.Skip(p, st)
+		}
+	default:
+		return w.d.Skip(p, st)
+	}
+
+	w.ls = append(w.ls, promLabel{k: promName(string(k), false), v: v})
+
+	return i
+}
+
+func (w *Prometheus) number(p []byte, st int) (v float64, ok bool, i int) {
+	tag, sub, _ := w.d.Tag(p, st)
+
+	switch {
+	case tag == tlwire.Int || tag == tlwire.Neg:
+		var x int64
+		x, i = w.d.Signed(p, st)
+
+		return float64(x), true, i
+	case tag == tlwire.Special && (sub == tlwire.Float64 || sub == tlwire.Float32 || sub == tlwire.Float16 || sub == tlwire.Float8):
+		v, i = w.d.Float(p, st)
+
+		return v, true, i
+	}
+
+	return 0, false, w.d.Skip(p, st)
+}
+
+func (w *Prometheus) string(p []byte, st int) (string, int) {
+	tag := w.d.TagOnly(p, st)
+	if tag != tlwire.String && tag != tlwire.Bytes {
+		return "", w.d.Skip(p, st)
+	}
+
+	s, i := w.d.Bytes(p, st)
+
+	return string(s), i
+}
+
+func (w *Prometheus) buckets(p []byte, st int) (bs []float64, i int) {
+	_, els, i := w.d.Tag(p, st)
+
+	bs = []float64{}
+
+	for el := 0; els == -1 || el < int(els); el++ {
+		if els == -1 && w.d.Break(p, &i) {
+			break
+		}
+
+		var v float64
+		var ok bool
+
+		v, ok, i = w.number(p, i)
+		if ok {
+			bs = append(bs, v)
+		}
+	}
+
+	sort.Float64s(bs)
+
+	return bs, i
+}
+
+// promName replaces chars not allowed in metric or label names with '_'.
+// Names starting with a digit are prefixed with '_'.
+func promName(s string, metric bool) string {
+	valid := func(i int, c rune) bool {
+		return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			i != 0 && c >= '0' && c <= '9' ||
+			metric && c == ':'
+	}
+
+	for i, c := range s {
+		if valid(i, c) {
+			continue
+		}
+
+		r := strings.Map(func(c rune) rune {
+			if valid(1, c) {
+				return c
+			}
+
+			return '_'
+		}, s)
+
+		if s[0] >= '0' && s[0] <= '9' {
+			r = "_" + r
+		}
+
+		return r
+	}
+
+	return s
+}
+
+func appendEscaped(b []byte, s string, quote bool) []byte {
+	for _, c := range []byte(s) {
+		switch {
+		case c == '\\':
+			b = append(b, '\\', '\\')
+		case c == '\n':
+			b = append(b, '\\', 'n')
+		case c == '"' && quote:
+			b = append(b, '\\', '"')
+		default:
+			b = append(b, c)
+		}
+	}
+
+	return b
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
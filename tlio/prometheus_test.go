@@ -0,0 +1,80 @@
+package tlio
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nikandfor/assert"
+
+	"tlog.app/go/tlog"
+)
+
+func TestPrometheus(t *testing.T) {
+	w := NewPrometheus()
+
+	l := tlog.New(w)
+	l.SetLabels("host", "h1")
+
+	c := l.NewCounter("http_requests", "Number of requests", "method", "GET")
+	c.Inc()
+	c.Add(2)
+
+	l.NewCounter("http_requests", "").Inc() // no labels
+
+	g := l.NewGauge("queue-size", "Queue\nsize")
+	g.Set(5)
+	g.Set(3)
+
+	h := l.NewHistogram("latency", "", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	l.Observe("temperature", 36.6, "where", `a "b"`, "type", "cpu")
+	l.Printw("not a metric", "v", 1)
+
+	exp := `# HELP http_requests Number of requests
+# TYPE http_requests counter
+http_requests{host="h1"} 1
+http_requests{host="h1",method="GET"} 3
+# TYPE latency histogram
+latency_bucket{host="h1",le="0.1"} 1
+latency_bucket{host="h1",le="1"} 2
+latency_bucket{host="h1",le="+Inf"} 3
+latency_sum{host="h1"} 2.55
+latency_count{host="h1"} 3
+# HELP queue_size Queue\nsize
+# TYPE queue_size gauge
+queue_size{host="h1"} 3
+# TYPE temperature untyped
+temperature{host="h1",type="cpu",where="a \"b\""} 36.6
+`
+
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, exp, string(body))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+}
+
+func TestPromName(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		metric bool
+		exp    string
+	}{
+		{"http_requests", true, "http_requests"},
+		{"queue-size", true, "queue_size"},
+		{"ns:name", true, "ns:name"},
+		{"ns:name", false, "ns_name"},
+		{"1xx_requests", true, "_1xx_requests"},
+		{"2label", false, "_2label"},
+		{"a1", false, "a1"},
+	} {
+		assert.Equal(t, tc.exp, promName(tc.in, tc.metric), "%q", tc.in)
+	}
+}
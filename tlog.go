@@ -27,7 +27,10 @@ type (
 		callers     func(skip int, pc *loc.PC, len, cap int) int `deep:"compare=pointer"`
 		callersSkip int
 
-		filter *filter // atomic access
+		filter  *filter  // atomic access
+		sampler *sampler // atomic access
+
+		metrics sync.Map `deep:"-"` // declared metric names
 
 		sync.Mutex
 
@@ -39,6 +42,8 @@ type (
 		Logger    *Logger
 		ID        ID
 		StartedAt time.Time
+
+		ctx string // pre-encoded kvs added by With
 	}
 
 	LogLevel int
@@ -92,6 +97,8 @@ var (
 	KeyLogLevel  = "_l"
 	KeyRepeated  = "_r"
 	KeyTag       = "_T"
+
+	KeyFirstTimestamp = "_tf" // first of repeated events
 )
 
 // Event kinds.
@@ -127,6 +134,7 @@ func (l *Logger) Copy(w io.Writer) *Logger {
 		callers:     l.callers,
 		callersSkip: l.callersSkip,
 		filter:      l.getfilter(),
+		sampler:     l.getsampler(),
 	}
 }
 
@@ -135,16 +143,39 @@ func (s Span) Copy(w io.Writer) Span {
 		Logger:    s.Logger.Copy(w),
 		ID:        s.ID,
 		StartedAt: s.StartedAt,
+		ctx:       s.ctx,
 	}
 }
 
-func message(l *Logger, id ID, d int, msg interface{}, kvs []interface{}) {
+func message(l *Logger, id ID, ctx string, d int, msg interface{}, kvs []interface{}) {
 	if l == nil {
 		return
 	}
 
 	e := &l.Encoder
 
+	var c loc.PC
+
+	if d >= 0 && l.callers != nil {
+		l.callers(2+d+l.callersSkip, (*loc.PC)(noescape(unsafe.Pointer(&c))), 1, 1)
+	}
+
+	var rep int64
+
+	if s := l.getsampler(); s != nil && d >= 0 {
+		pc := c
+		if pc == 0 {
+			caller1(2+d, &pc, 1, 1)
+		}
+
+		var ok bool
+
+		ok, rep = s.sample(pc, nanotime(l))
+		if !ok {
+			return
+		}
+	}
+
 	defer l.Unlock()
 	l.Lock()
 
@@ -162,9 +193,7 @@ func message(l *Logger, id ID, d int, msg interface{}, kvs []interface{}) {
 		l.b = e.AppendTimestamp(l.b, now)
 	}
 
-	var c loc.PC
-
-	if d >= 0 && l.callers != nil && l.callers(2+d+l.callersSkip, (*loc.PC)(noescape(unsafe.Pointer(&c))), 1, 1) != 0 {
+	if c != 0 {
 		l.b = e.AppendKey(l.b, KeyCaller)
 		l.b = e.AppendCaller(l.b, c)
 	}
@@ -185,8 +214,13 @@ func message(l *Logger, id ID, d int, msg interface{}, kvs []interface{}) {
 		}
 	}
 
+	if rep != 0 {
+		l.b = e.AppendKeyInt64(l.b, KeyRepeated, rep)
+	}
+
 	l.b = AppendKVs(e, l.b, kvs)
 
+	l.b = append(l.b, ctx...)
 	l.b = append(l.b, l.ls...)
 
 	l.b = e.AppendBreak(l.b)
@@ -194,13 +228,14 @@ func message(l *Logger, id ID, d int, msg interface{}, kvs []interface{}) {
 	_, _ = l.Writer.Write(l.b)
 }
 
-func newspan(l *Logger, par ID, d int, n string, kvs []interface{}) (s Span) {
+func newspan(l *Logger, par ID, ctx string, d int, n string, kvs []interface{}) (s Span) {
 	if l == nil {
 		return
 	}
 
 	s.Logger = l
 	s.ID = l.NewID()
+	s.ctx = ctx
 	if l.now != nil {
 		s.StartedAt = l.now()
 	}
@@ -244,6 +279,7 @@ func newspan(l *Logger, par ID, d int, n string, kvs []interface{}) (s Span) {
 
 	l.b = AppendKVs(e, l.b, kvs)
 
+	l.b = append(l.b, s.ctx...)
 	l.b = append(l.b, l.ls...)
 
 	l.b = e.AppendBreak(l.b)
@@ -289,6 +325,7 @@ func (s Span) Finish(kvs ...interface{}) {
 
 	l.b = AppendKVs(e, l.b, kvs)
 
+	l.b = append(l.b, s.ctx...)
 	l.b = append(l.b, l.ls...)
 
 	l.b = e.AppendBreak(l.b)
@@ -316,7 +353,7 @@ func (l *Logger) Labels() RawMessage {
 }
 
 func Start(name string, kvs ...interface{}) Span {
-	return newspan(DefaultLogger, ID{}, 0, name, kvs)
+	return newspan(DefaultLogger, ID{}, "", 0, name, kvs)
 }
 
 func (l *Logger) Or(l2 *Logger) *Logger {
@@ -376,6 +413,7 @@ func (s Span) Event(kvs ...interface{}) (err error) {
 
 	l.b = AppendKVs(e, l.b, kvs)
 
+	l.b = append(l.b, s.ctx...)
 	l.b = append(l.b, l.ls...)
 
 	l.b = l.AppendBreak(l.b)
@@ -386,47 +424,66 @@ func (s Span) Event(kvs ...interface{}) (err error) {
 }
 
 func (l *Logger) NewSpan(d int, par ID, name string, kvs ...interface{}) Span {
-	return newspan(l, par, d, name, kvs)
+	return newspan(l, par, "", d, name, kvs)
 }
 
 func (l *Logger) NewMessage(d int, id ID, msg interface{}, kvs ...interface{}) {
-	message(l, id, d, msg, kvs)
+	message(l, id, "", d, msg, kvs)
 }
 
 func (s Span) NewMessage(d int, msg interface{}, kvs ...interface{}) {
-	message(s.Logger, s.ID, d, msg, kvs)
+	message(s.Logger, s.ID, s.ctx, d, msg, kvs)
 }
 
 func (l *Logger) Start(name string, kvs ...interface{}) Span {
-	return newspan(l, ID{}, 0, name, kvs)
+	return newspan(l, ID{}, "", 0, name, kvs)
 }
 
 func (s Span) Spawn(name string, kvs ...interface{}) Span {
-	return newspan(s.Logger, s.ID, 0, name, kvs)
+	return newspan(s.Logger, s.ID, s.ctx, 0, name, kvs)
+}
+
+// With returns root Span adding kvs to each its event.
+// See Span.With.
+func (l *Logger) With(kvs ...interface{}) Span {
+	return Span{Logger: l}.With(kvs...)
+}
+
+// With returns a copy of s adding kvs to each its event and events of its children.
+// kvs are encoded once here, not on each event.
+func (s Span) With(kvs ...interface{}) Span {
+	if s.Logger == nil || len(kvs) == 0 {
+		return s
+	}
+
+	b := AppendKVs(&s.Logger.Encoder, []byte(s.ctx), kvs)
+	s.ctx = string(b)
+
+	return s
 }
 
 func Printw(msg string, kvs ...interface{}) {
-	message(DefaultLogger, ID{}, 0, msg, kvs)
+	message(DefaultLogger, ID{}, "", 0, msg, kvs)
 }
 
 func (l *Logger) Printw(msg string, kvs ...interface{}) {
-	message(l, ID{}, 0, msg, kvs)
+	message(l, ID{}, "", 0, msg, kvs)
 }
 
 func (s Span) Printw(msg string, kvs ...interface{}) {
-	message(s.Logger, s.ID, 0, msg, kvs)
+	message(s.Logger, s.ID, s.ctx, 0, msg, kvs)
 }
 
 func Printf(fmt string, args ...interface{}) {
-	message(DefaultLogger, ID{}, 0, format{Fmt: fmt, Args: args}, nil)
+	message(DefaultLogger, ID{}, "", 0, format{Fmt: fmt, Args: args}, nil)
 }
 
 func (l *Logger) Printf(fmt string, args ...interface{}) {
-	message(l, ID{}, 0, format{Fmt: fmt, Args: args}, nil)
+	message(l, ID{}, "", 0, format{Fmt: fmt, Args: args}, nil)
 }
 
 func (s Span) Printf(fmt string, args ...interface{}) {
-	message(s.Logger, s.ID, 0, format{Fmt: fmt, Args: args}, nil)
+	message(s.Logger, s.ID, s.ctx, 0, format{Fmt: fmt, Args: args}, nil)
 }
 
 func (l *Logger) IOWriter(d int) io.Writer {
@@ -464,13 +521,13 @@ func (s Span) DumpWriter(d int, msg, key string, kvs ...any) io.Writer {
 }
 
 func (w writeWrapper) Write(p []byte) (int, error) {
-	message(w.Logger, w.ID, w.d, p, nil)
+	message(w.Logger, w.ID, w.Span.ctx, w.d, p, nil)
 
 	return len(p), nil
 }
 
 func (w *dumpWrapper) Write(p []byte) (int, error) {
-	message(w.Logger, w.ID, w.d, w.msg, []any{w, w.key, p})
+	message(w.Logger, w.ID, w.Span.ctx, w.d, w.msg, []any{w, w.key, p})
 
 	return len(p), nil
 }
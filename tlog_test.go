@@ -3,6 +3,7 @@ package tlog
 import (
 	"io"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 
@@ -214,3 +215,43 @@ func BenchmarkLoggerStartPrintwFinish(b *testing.B) {
 		tr.Finish()
 	}
 }
+
+func TestSpanWith(t *testing.T) {
+	var buf low.Buf
+
+	l := New(NewConsoleWriter(&buf, 0))
+	LoggerSetTimeNow(l, nil, nil)
+
+	var id byte
+	l.NewID = func() ID { id++; return ID{id} }
+
+	l.With("svc", "api").Printw("logger")
+
+	tr := l.Start("request")
+	w := tr.With("request_id", 5)
+
+	w.Printw("message", "a", 1)
+	tr.Printw("plain")
+
+	ch := w.With("b", 2).Spawn("child")
+	ch.Printw("child message")
+	ch.Finish()
+
+	w.Finish()
+
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	for i := range lines {
+		lines[i] = strings.Join(strings.Fields(lines[i]), " ")
+	}
+
+	assert.Equal(t, []string{
+		"logger svc=api",
+		"request _s=01000000 _k=s",
+		"message _s=01000000 a=1 request_id=5",
+		"plain _s=01000000",
+		"child _s=02000000 _k=s _p=01000000 request_id=5 b=2",
+		"child message _s=02000000 request_id=5 b=2",
+		"_s=02000000 _k=f request_id=5 b=2",
+		"_s=01000000 _k=f request_id=5",
+	}, lines)
+}
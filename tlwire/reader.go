@@ -91,8 +91,28 @@ func (d *Reader) skipRead() (end int, err error) {
 	}
 }
 
-func (d *Reader) skip(st int) (i int) {
-	tag, sub, i := readTag(d.b, st)
+// ValueEnd returns the end of the value starting at st.
+// Unlike Decoder it checks bounds and returns an error
+// if the value is truncated or malformed instead of panicking.
+func ValueEnd(p []byte, st int) (end int, err error) {
+	end = skip(p, st)
+
+	switch {
+	case end >= 0:
+		return end, nil
+	case end == eUnexpectedEOF:
+		return 0, io.ErrUnexpectedEOF
+	default:
+		return 0, errors.New("bad format")
+	}
+}
+
+func (d *Reader) skip(st int) int {
+	return skip(d.b, st)
+}
+
+func skip(b []byte, st int) (i int) {
+	tag, sub, i := readTag(b, st)
 	//	println("tag", st, tag, sub, i)
 	if i < 0 {
 		return i
@@ -105,30 +125,30 @@ func (d *Reader) skip(st int) (i int) {
 		i += int(sub)
 	case Array, Map:
 		for el := 0; sub == -1 || el < int(sub); el++ {
-			if i == len(d.b) {
+			if i == len(b) {
 				return eUnexpectedEOF
 			}
-			if sub == -1 && Tag(d.b[i]) == Special|Break {
+			if sub == -1 && Tag(b[i]) == Special|Break {
 				i++
 				break
 			}
 
 			if tag == Map {
-				i = d.skip(i)
+				i = skip(b, i)
 				if i < 0 {
 					return i
 				}
 			}
 
-			i = d.skip(i)
+			i = skip(b, i)
 			if i < 0 {
 				return i
 			}
 		}
 	case Semantic:
-		return d.skip(i)
+		return skip(b, i)
 	case Special:
-		sub := Tag(d.b[st]) & SubMask
+		sub := Tag(b[st]) & SubMask
 
 		switch sub {
 		case False,
@@ -149,7 +169,7 @@ func (d *Reader) skip(st int) (i int) {
 		}
 	}
 
-	if i > len(d.b) {
+	if i > len(b) {
 		return eUnexpectedEOF
 	}
 
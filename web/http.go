@@ -0,0 +1,240 @@
+package web
+
+import (
+	"compress/gzip"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"tlog.app/go/errors"
+
+	"tlog.app/go/tlog"
+)
+
+type (
+	// connListener is a net.Listener serving a single conn.
+	// Accept blocks after the conn is returned until Close is called.
+	connListener struct {
+		conns chan net.Conn
+		addr  net.Addr
+
+		once sync.Once
+		done chan struct{}
+	}
+
+	// gzipWriter compresses the response if the client accepts it.
+	// The header is written lazily, so content type can be detected
+	// from uncompressed data and Content-Length can be dropped.
+	gzipWriter struct {
+		http.ResponseWriter
+
+		z *gzip.Writer
+
+		code    int
+		gzip    bool
+		written bool
+	}
+)
+
+const readHeaderTimeout = 10 * time.Second
+
+// ServeHTTP implements http.Handler.
+// Responses are gzip compressed if the client supports it.
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	gw := newGzipWriter(rw, req)
+
+	err := s.HandleRequest(ctx, gw, req)
+	if err != nil && !gw.written {
+		gw.gzip = false
+		http.Error(gw, err.Error(), http.StatusInternalServerError)
+	}
+
+	e := gw.Close()
+	if err == nil {
+		err = e
+	}
+
+	if err != nil {
+		tlog.SpanFromContext(ctx).Printw("request failed", "method", req.Method, "url", req.URL, "err", err)
+	}
+}
+
+// HandleConn serves HTTP/1.1 requests on c until the client closes the connection or ctx is canceled.
+func (s *Server) HandleConn(ctx context.Context, c net.Conn) (err error) {
+	l := &connListener{
+		conns: make(chan net.Conn, 1),
+		addr:  c.LocalAddr(),
+		done:  make(chan struct{}),
+	}
+
+	l.conns <- c
+
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ConnContext: func(context.Context, net.Conn) context.Context {
+			return ctx
+		},
+		ConnState: func(_ net.Conn, st http.ConnState) {
+			if st == http.StateClosed || st == http.StateHijacked {
+				_ = l.Close()
+			}
+		},
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = srv.Close()
+	})
+	defer stop()
+
+	err = srv.Serve(l)
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	return err
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
+
+func newGzipWriter(rw http.ResponseWriter, req *http.Request) *gzipWriter {
+	w := &gzipWriter{
+		ResponseWriter: rw,
+		code:           http.StatusOK,
+	}
+
+	rw.Header().Add("Vary", "Accept-Encoding")
+
+	w.gzip = req.Method != http.MethodHead &&
+		req.Header.Get("Range") == "" &&
+		acceptsGzip(req.Header.Get("Accept-Encoding")) &&
+		pathExt(req.URL.Path) != ".tlz"
+
+	return w
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.written {
+		return
+	}
+
+	w.code = code
+	w.written = true
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	w.written = true
+
+	if w.z == nil {
+		w.writeHeader(p)
+	}
+
+	if w.z != nil {
+		return w.z.Write(p)
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipWriter) Flush() {
+	if w.z == nil {
+		w.writeHeader(nil)
+	}
+
+	if w.z != nil {
+		_ = w.z.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the compressed stream.
+func (w *gzipWriter) Close() error {
+	if !w.written {
+		return nil
+	}
+
+	if w.z == nil {
+		w.writeHeader(nil)
+	}
+
+	if w.z == nil {
+		return nil
+	}
+
+	return w.z.Close()
+}
+
+func (w *gzipWriter) writeHeader(p []byte) {
+	if w.code == 0 {
+		return
+	}
+
+	code := w.code
+	w.code = 0
+
+	h := w.Header()
+
+	if h.Get("Content-Type") == "" && len(p) != 0 {
+		h.Set("Content-Type", http.DetectContentType(p))
+	}
+
+	if w.gzip && bodyAllowed(code) && h.Get("Content-Encoding") == "" && !compressed(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+
+		w.z = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func acceptsGzip(ae string) bool {
+	for _, e := range strings.Split(ae, ",") {
+		e, params, _ := strings.Cut(e, ";")
+
+		if strings.TrimSpace(e) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+func compressed(ct string) bool {
+	switch {
+	case strings.HasPrefix(ct, "image/") && !strings.HasPrefix(ct, "image/svg"),
+		strings.HasPrefix(ct, "application/x-tlz"),
+		strings.HasPrefix(ct, "application/gzip"),
+		strings.HasPrefix(ct, "application/zip"):
+		return true
+	}
+
+	return false
+}
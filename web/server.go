@@ -1,7 +1,7 @@
 package web
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"embed"
 	"fmt"
@@ -20,6 +20,7 @@ import (
 
 	"tlog.app/go/tlog"
 	"tlog.app/go/tlog/convert"
+	"tlog.app/go/tlog/tlwire"
 )
 
 type (
@@ -27,19 +28,50 @@ type (
 		Query(ctx context.Context, w io.Writer, ts int64, q string) error
 	}
 
+	// PageAgent is an Agent supporting paged queries.
+	// ts query parameter is the page cursor and limit is the page size,
+	// negative to page backwards. See agent.Agent.QueryPage.
+	PageAgent interface {
+		QueryPage(ctx context.Context, w io.Writer, now, cursor int64, limit int, q string) error
+	}
+
+	// SubscribeAgent is an Agent streaming new events.
+	SubscribeAgent interface {
+		Subscribe(ctx context.Context, w io.Writer, q string) (int64, error)
+		Unsubscribe(ctx context.Context, id int64) error
+	}
+
+	// TraceAgent is an Agent gathering trace events.
+	TraceAgent interface {
+		Trace(ctx context.Context, w io.Writer, id tlog.ID, q string) error
+	}
+
+	// MetricsAgent is an Agent exposing its own metrics.
+	MetricsAgent interface {
+		WriteMetrics(l *tlog.Logger)
+	}
+
 	Server struct {
 		Agent Agent
 		FS    http.FileSystem
 	}
 
-	response struct {
-		req *http.Request
+	// tailWriter guards the response from subscription writes
+	// after the handler returned.
+	tailWriter struct {
+		mu  sync.Mutex
 		w   io.Writer
-		h   http.Header
+		f   http.Flusher
+		err error
+
+		cancel func()
+	}
+
+	// sseWriter wraps json lines into server-sent events.
+	sseWriter struct {
+		io.Writer
 
-		once    sync.Once
-		nl      bool
-		written bool
+		b []byte
 	}
 
 	Proto func(context.Context, net.Conn) error
@@ -80,103 +112,134 @@ func (s *Server) Serve(ctx context.Context, l net.Listener, proto Proto) (err er
 	}
 }
 
-func (s *Server) HandleConn(ctx context.Context, c net.Conn) (err error) {
-	defer func() {
-		e := c.Close()
-		if err == nil {
-			err = errors.Wrap(e, "close conn")
+func (s *Server) HandleRequest(ctx context.Context, rw http.ResponseWriter, req *http.Request) (err error) {
+	tr := tlog.SpanFromContext(ctx)
+	p := req.URL.Path
+
+	tr.Printw("request", "method", req.Method, "url", req.URL)
+
+	switch {
+	case strings.HasPrefix(p, "/v0/events"):
+		now := time.Now().UnixNano()
+		limit := queryInt64(req.URL, "limit", 0)
+
+		// ts is the page cursor if limit is set:
+		// forward pages start from the beginning, backward pages from now.
+		def := now
+		if limit > 0 {
+			def = 0
 		}
-	}()
 
-	c = hnet.NewStoppableConn(ctx, c)
+		ts := queryInt64(req.URL, "ts", def)
 
-	br := bufio.NewReader(c)
+		pa, ok := s.Agent.(PageAgent)
+		if limit != 0 && !ok {
+			return errors.New("paging is not supported")
+		}
 
-	req, err := http.ReadRequest(br)
-	if errors.Is(err, io.EOF) {
-		return nil
-	}
-	if err != nil {
-		return errors.Wrap(err, "read request")
-	}
+		var qdata []byte
 
-	ctx, cancel := context.WithCancel(ctx)
+		qdata, err = io.ReadAll(req.Body)
+		if err != nil {
+			return errors.Wrap(err, "read query")
+		}
 
-	go func() {
-		defer cancel()
+		var w io.Writer
 
-		_, _ = io.Copy(io.Discard, c)
-	}()
+		ext := pathExt(p)
+		if ext == "" {
+			ext = ".json"
+		}
 
-	resp := &response{
-		req: req,
-		w:   c,
-	}
+		rw.Header().Set("Content-Type", contentType(ext))
 
-	defer func() {
-		if resp.written {
-			return
+		w, err = formatWriter(rw, ext)
+		if err != nil {
+			return err
 		}
 
-		if err == nil {
-			resp.WriteHeader(http.StatusNotFound)
-			return
+		if c, ok := w.(io.Closer); ok {
+			defer hnet.Closer(c, &err, "close writer")
 		}
 
-		resp.WriteHeader(http.StatusInternalServerError)
-		_, _ = fmt.Fprintf(resp, "%v\n", err)
-	}()
+		if limit != 0 {
+			err = pa.QueryPage(ctx, w, now, ts, int(limit), string(qdata))
+		} else {
+			err = s.Agent.Query(ctx, w, ts, string(qdata))
+		}
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "process query")
+		}
 
-	err = s.HandleRequest(ctx, resp, req)
-	if err != nil {
-		return err
-	}
+		return nil
+	case strings.HasPrefix(p, "/v0/trace/"):
+		return s.handleTrace(ctx, rw, req)
+	case strings.HasPrefix(p, "/v0/tail"):
+		return s.handleTail(ctx, rw, req)
+	case strings.HasPrefix(p, "/v0/metrics"):
+		m, ok := s.Agent.(MetricsAgent)
+		if !ok {
+			http.NotFound(rw, req)
+			return nil
+		}
 
-	return nil // TODO: handle Content-Length
-}
+		var w io.Writer
 
-func (s *Server) HandleRequest(ctx context.Context, rw http.ResponseWriter, req *http.Request) (err error) {
-	tr := tlog.SpanFromContext(ctx)
-	p := req.URL.Path
+		ext := pathExt(p)
+		if ext == "" {
+			ext = ".json"
+		}
 
-	tr.Printw("request", "method", req.Method, "url", req.URL)
+		rw.Header().Set("Content-Type", contentType(ext))
 
-	switch {
-	case strings.HasPrefix(p, "/v0/events"):
-		ts := queryInt64(req.URL, "ts", time.Now().UnixNano())
+		w, err = formatWriter(rw, ext)
+		if err != nil {
+			return err
+		}
 
-		var qdata []byte
+		if c, ok := w.(io.Closer); ok {
+			defer hnet.Closer(c, &err, "close writer")
+		}
 
-		qdata, err = io.ReadAll(req.Body)
-		if err != nil {
-			return errors.Wrap(err, "read query")
+		m.WriteMetrics(tlog.New(w))
+
+		return nil
+	case strings.HasPrefix(p, "/v0/write"):
+		if req.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return nil
 		}
 
-		var w io.Writer = rw
-
-		switch ext := pathExt(p); ext {
-		case ".tl", ".tlog":
-		case ".tlz":
-			w = eazy.NewWriter(w, eazy.MiB, 2*1024)
-		case ".json":
-			w = convert.NewJSON(w)
-		case ".logfmt":
-			w = convert.NewLogfmt(w)
-		case ".html":
-			ww := convert.NewWeb(w)
-			defer hnet.Closer(ww, &err, "close Web")
-
-			w = ww
-		default:
-			return errors.New("unsupported ext: %v", ext)
+		w, ok := s.Agent.(io.Writer)
+		if !ok {
+			http.NotFound(rw, req)
+			return nil
 		}
 
-		err = s.Agent.Query(ctx, w, ts, string(qdata))
-		if errors.Is(err, context.Canceled) {
-			err = nil
+		ext := pathExt(p)
+		if ext == "" {
+			ext = contentTypeExt(req.Header.Get("Content-Type"))
+		}
+
+		var r io.WriterTo
+
+		r, err = formatReader(req.Body, ext)
+		if err != nil {
+			return err
 		}
 
-		return errors.Wrap(err, "process query")
+		_, err = r.WriteTo(w)
+		if err != nil {
+			return errors.Wrap(err, "write events")
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+
+		return nil
 	default:
 		http.FileServer(s.FS).ServeHTTP(rw, req)
 	}
@@ -184,42 +247,260 @@ func (s *Server) HandleRequest(ctx context.Context, rw http.ResponseWriter, req
 	return nil
 }
 
-func (r *response) WriteHeader(code int) {
-	r.once.Do(func() {
-		fmt.Fprintf(r.w, "HTTP/%d.%d %03d %s\r\n", 1, 0, code, http.StatusText(code))
+// handleTrace serves /v0/trace/<id>[.ext] with all the events of the span and its descendants.
+// The default format is json.
+func (s *Server) handleTrace(ctx context.Context, rw http.ResponseWriter, req *http.Request) (err error) {
+	ta, ok := s.Agent.(TraceAgent)
+	if !ok {
+		http.NotFound(rw, req)
+		return nil
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/v0/trace/")
+
+	ext := pathExt(name)
+	name = strings.TrimSuffix(name, ext)
+
+	if ext == "" {
+		ext = ".json"
+	}
+
+	id, err := tlog.IDFromString(name)
+	if err != nil || id == (tlog.ID{}) {
+		http.Error(rw, fmt.Sprintf("bad trace id: %q", name), http.StatusBadRequest)
+		return nil
+	}
+
+	q, err := readQuery(req)
+	if err != nil {
+		return err
+	}
+
+	rw.Header().Set("Content-Type", contentType(ext))
+
+	w, err := formatWriter(rw, ext)
+	if err != nil {
+		return err
+	}
+
+	if c, ok := w.(io.Closer); ok {
+		defer hnet.Closer(c, &err, "close writer")
+	}
+
+	err = ta.Trace(ctx, w, id, q)
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "trace")
+	}
+
+	return nil
+}
+
+// handleTail streams new events matching the query until the client disconnects.
+// Events are sent as server-sent events with json data if no ext is given,
+// or as a continuous response of the ext format otherwise.
+// The query is taken from q parameter or from the body.
+func (s *Server) handleTail(ctx context.Context, rw http.ResponseWriter, req *http.Request) (err error) {
+	sa, ok := s.Agent.(SubscribeAgent)
+	if !ok {
+		http.NotFound(rw, req)
+		return nil
+	}
 
-		for k, v := range r.h {
-			fmt.Fprintf(r.w, "%s:", k)
+	q, err := readQuery(req)
+	if err != nil {
+		return err
+	}
+
+	ext := pathExt(req.URL.Path)
+
+	var w io.Writer
 
-			for _, v := range v {
-				fmt.Fprintf(r.w, " %s", v)
-			}
+	if ext == "" {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
 
-			fmt.Fprintf(r.w, "\r\n")
+		w = convert.NewJSON(&sseWriter{Writer: rw})
+	} else {
+		rw.Header().Set("Content-Type", contentType(ext))
+
+		w, err = formatWriter(rw, ext)
+		if err != nil {
+			return err
 		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tw := &tailWriter{
+		w:      w,
+		cancel: cancel,
+	}
+
+	tw.f, _ = rw.(http.Flusher)
+
+	// events are not written until the header is sent
+	tw.mu.Lock()
+
+	id, err := sa.Subscribe(ctx, tw, q)
+	if err != nil {
+		tw.mu.Unlock()
+		return errors.Wrap(err, "subscribe")
+	}
+
+	rw.WriteHeader(http.StatusOK)
+
+	if tw.f != nil {
+		tw.f.Flush()
+	}
+
+	tw.mu.Unlock()
 
-		fmt.Fprintf(r.w, "\r\n")
-	})
+	<-ctx.Done()
+
+	_ = sa.Unsubscribe(context.Background(), id)
+
+	err = tw.stop()
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+
+	if c, ok := w.(io.Closer); ok && err == nil {
+		err = c.Close()
+	}
+
+	return err
+}
+
+// readQuery returns the query from q parameter or from the body.
+func readQuery(req *http.Request) (string, error) {
+	if q := req.URL.Query().Get("q"); q != "" {
+		return q, nil
+	}
+
+	q, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "read query")
+	}
+
+	return string(q), nil
 }
 
-func (r *response) Header() http.Header {
-	if r.h == nil {
-		r.h = make(http.Header)
+func formatWriter(w io.Writer, ext string) (io.Writer, error) {
+	switch ext {
+	case ".tl", ".tlog":
+		return w, nil
+	case ".tlz":
+		return eazy.NewWriter(w, eazy.MiB, 2*1024), nil
+	case ".json":
+		return convert.NewJSON(w), nil
+	case ".logfmt":
+		return convert.NewLogfmt(w), nil
+	case ".html":
+		return convert.NewWeb(w), nil
+	default:
+		return nil, errors.New("unsupported ext: %v", ext)
 	}
+}
 
-	return r.h
+func contentType(ext string) string {
+	switch ext {
+	case ".tl", ".tlog":
+		return "application/x-tlog"
+	case ".tlz":
+		return "application/x-tlz"
+	case ".json":
+		return "application/x-ndjson"
+	case ".logfmt":
+		return "text/plain; charset=utf-8"
+	case ".html":
+		return "text/html; charset=utf-8"
+	default:
+		return ""
+	}
 }
 
-func (r *response) Write(p []byte) (n int, err error) {
-	r.WriteHeader(http.StatusOK)
+func formatReader(r io.Reader, ext string) (io.WriterTo, error) {
+	switch ext {
+	case ".tl", ".tlog":
+		return tlwire.NewReader(r), nil
+	case ".tlz":
+		return tlwire.NewReader(eazy.NewReader(r)), nil
+	case ".json":
+		return convert.NewJSONReader(r), nil
+	case ".logfmt":
+		return convert.NewLogfmtReader(r), nil
+	default:
+		return nil, errors.New("unsupported ext: %v", ext)
+	}
+}
 
-	n, err = r.w.Write(p)
+func contentTypeExt(ct string) string {
+	ct, _, _ = strings.Cut(ct, ";")
 
-	if n < len(p) {
-		r.nl = p[n] == '\n'
+	switch strings.TrimSpace(ct) {
+	case "application/x-tlog":
+		return ".tl"
+	case "application/x-tlz":
+		return ".tlz"
+	case "application/json", "application/x-ndjson":
+		return ".json"
+	default:
+		return ".tl"
+	}
+}
+
+func (w *tailWriter) Write(p []byte) (n int, err error) {
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err = w.w.Write(p)
+	if err != nil {
+		w.err = err
+		w.cancel()
+
+		return n, err
+	}
+
+	if w.f != nil {
+		w.f.Flush()
+	}
+
+	return n, nil
+}
+
+// stop makes following writes fail and returns the first write error.
+func (w *tailWriter) stop() (err error) {
+	defer w.mu.Unlock()
+	w.mu.Lock()
+
+	err = w.err
+
+	if w.err == nil {
+		w.err = context.Canceled
+	}
+
+	return err
+}
+
+func (w *sseWriter) Write(p []byte) (n int, err error) {
+	w.b = append(w.b[:0], "data: "...)
+	w.b = append(w.b, bytes.TrimRight(p, "\n")...)
+	w.b = append(w.b, "\n\n"...)
+
+	_, err = w.Writer.Write(w.b)
+	if err != nil {
+		return 0, err
 	}
 
-	return
+	return len(p), nil
 }
 
 func pathExt(name string) string {
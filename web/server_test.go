@@ -0,0 +1,335 @@
+package web
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikandfor/assert"
+	"nikand.dev/go/hacked/low"
+	"tlog.app/go/eazy"
+
+	"tlog.app/go/tlog"
+	"tlog.app/go/tlog/agent"
+	"tlog.app/go/tlog/tlwire"
+)
+
+type testAgent struct {
+	events [][]byte
+}
+
+func TestWrite(t *testing.T) {
+	var b low.Buf
+
+	l := tlog.New(&b)
+	l.Printw("first", "i", 1)
+	l.Printw("second", "i", 2)
+
+	var z low.Buf
+
+	_, err := eazy.NewWriter(&z, eazy.MiB, 1024).Write(b)
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		path string
+		ct   string
+		body string
+	}{
+		{"tlwire", "/v0/write", "", string(b)},
+		{"tlwire_ext", "/v0/write.tl", "application/json", string(b)},
+		{"tlz", "/v0/write", "application/x-tlz", string(z)},
+		{"json", "/v0/write", "application/json", `{"msg":"first","i":1}` + "\n" + `{"msg":"second","i":2}`},
+		{"json_ext", "/v0/write.json", "", `{"msg":"first","i":1} {"msg":"second","i":2}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &testAgent{}
+			s := &Server{Agent: a}
+
+			req, err := http.NewRequest(http.MethodPost, "http://localhost"+tc.path, strings.NewReader(tc.body))
+			assert.NoError(t, err)
+
+			if tc.ct != "" {
+				req.Header.Set("Content-Type", tc.ct)
+			}
+
+			resp, _ := roundTrip(t, s, req)
+
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+			assert.Equal(t, 2, len(a.events))
+
+			var d tlwire.Decoder
+
+			for _, e := range a.events {
+				assert.Equal(t, len(e), d.Skip(e, 0))
+			}
+		})
+	}
+}
+
+func TestWriteMethod(t *testing.T) {
+	s := &Server{Agent: &testAgent{}}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/v0/write", nil)
+	assert.NoError(t, err)
+
+	resp, _ := roundTrip(t, s, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestMetrics(t *testing.T) {
+	s := &Server{Agent: &testAgent{}}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/v0/metrics", nil)
+	assert.NoError(t, err)
+
+	resp, body := roundTrip(t, s, req)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentType(".json"), resp.Header.Get("Content-Type"))
+	assert.True(t, strings.Contains(string(body), `"events":3`), "body: %s", body)
+}
+
+func TestEventsPage(t *testing.T) {
+	a, err := agent.New(t.TempDir())
+	assert.NoError(t, err)
+
+	s := &Server{Agent: a}
+
+	base := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	l := tlog.New(a)
+
+	for i := range 5 {
+		now := base.Add(time.Duration(i) * time.Second)
+		tlog.LoggerSetTimeNow(l, func() time.Time { return now }, now.UnixNano)
+
+		l.Printw("message", "i", i)
+	}
+
+	for _, tc := range []struct {
+		query string
+		exp   []string
+	}{
+		{"limit=2", []string{`"i":0`, `"i":1`}},
+		{"limit=-2", []string{`"i":3`, `"i":4`}},
+		{fmt.Sprintf("limit=2&ts=%d", base.Add(2*time.Second).UnixNano()), []string{`"i":2`, `"i":3`}},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost/v0/events?"+tc.query, nil)
+		assert.NoError(t, err)
+
+		resp, body := roundTrip(t, s, req)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		assert.Equal(t, len(tc.exp), len(lines), "%v: %s", tc.query, body)
+
+		for i, line := range lines {
+			if i < len(tc.exp) {
+				assert.True(t, strings.Contains(line, tc.exp[i]), "%v: line %d: %s", tc.query, i, line)
+			}
+		}
+	}
+}
+
+func TestTail(t *testing.T) {
+	a, err := agent.New(t.TempDir())
+	assert.NoError(t, err)
+
+	s := &Server{Agent: a}
+
+	c, sc := net.Pipe()
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- s.HandleConn(context.Background(), sc)
+	}()
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/v0/tail?q="+url.QueryEscape("i>=1"), nil)
+	assert.NoError(t, err)
+
+	go func() {
+		_ = req.Write(c)
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	br := bufio.NewReader(resp.Body)
+
+	l := tlog.New(a)
+
+	for i := range 3 {
+		l.Printw("message", "i", i)
+	}
+
+	for i := 1; i < 3; i++ {
+		line, err := br.ReadString('\n')
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(line, "data: {") && strings.Contains(line, fmt.Sprintf(`"i":%d`, i)), "line: %q", line)
+
+		line, err = br.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "\n", line)
+	}
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = <-errc
+	assert.NoError(t, err)
+}
+
+func TestTrace(t *testing.T) {
+	a, err := agent.New(t.TempDir())
+	assert.NoError(t, err)
+
+	s := &Server{Agent: a}
+
+	l := tlog.New(a)
+
+	root := l.Start("root")
+	other := l.Start("other")
+	child := root.Spawn("child")
+	child.Printw("child message")
+	other.Printw("other message")
+	child.Finish()
+	root.Finish()
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/v0/trace/"+root.ID.StringFull(), nil)
+	assert.NoError(t, err)
+
+	resp, body := roundTrip(t, s, req)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	assert.Equal(t, 5, len(lines), "body: %s", body)
+	assert.False(t, strings.Contains(string(body), "other"), "body: %s", body)
+
+	req, err = http.NewRequest(http.MethodGet, "http://localhost/v0/trace/nope.json", nil)
+	assert.NoError(t, err)
+
+	resp, _ = roundTrip(t, s, req)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestKeepAlive(t *testing.T) {
+	s, err := New(&testAgent{})
+	assert.NoError(t, err)
+
+	c, sc := net.Pipe()
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- s.HandleConn(context.Background(), sc)
+	}()
+
+	br := bufio.NewReader(c)
+
+	for _, tc := range []struct {
+		path string
+		file string
+		gzip bool
+	}{
+		{"/", "index.html", false},
+		{"/static/tlog.js", "static/tlog.js", true},
+		{"/static/styles.css", "static/styles.css", false},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost"+tc.path, nil)
+		assert.NoError(t, err)
+
+		if tc.gzip {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+
+		go func() {
+			_ = req.Write(c)
+		}()
+
+		resp, err := http.ReadResponse(br, req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.False(t, resp.Close, "keep-alive")
+
+		var r io.Reader = resp.Body
+
+		if tc.gzip {
+			assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+			r, err = gzip.NewReader(resp.Body)
+			assert.NoError(t, err)
+		}
+
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+
+		exp, err := fs.ReadFile(static, tc.file)
+		assert.NoError(t, err)
+		assert.Equal(t, string(exp), string(data))
+	}
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = <-errc
+	assert.NoError(t, err)
+}
+
+func roundTrip(t *testing.T, s *Server, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+
+	c, sc := net.Pipe()
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- s.HandleConn(context.Background(), sc)
+	}()
+
+	go func() {
+		_ = req.Write(c)
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	assert.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	err = <-errc
+	assert.NoError(t, err)
+
+	return resp, body
+}
+
+func (a *testAgent) Query(ctx context.Context, w io.Writer, ts int64, q string) error {
+	return nil
+}
+
+func (a *testAgent) Write(p []byte) (int, error) {
+	a.events = append(a.events, append([]byte{}, p...))
+
+	return len(p), nil
+}
+
+func (a *testAgent) WriteMetrics(l *tlog.Logger) {
+	l.Printw("agent", "events", 3)
+}
@@ -1,2 +1,319 @@
 (function() {
+	'use strict';
+
+	const maxRows = 5000;
+	const levels = {'-1': 'D', '0': 'I', '1': 'W', '2': 'E', '3': 'F'};
+	const special = ['_t', '_l', '_m', '_c'];
+
+	let source = null;
+
+	function el(tag, cls, text) {
+		const e = document.createElement(tag);
+
+		if (cls) e.className = cls;
+		if (text !== undefined) e.textContent = text;
+
+		return e;
+	}
+
+	function formatValue(v) {
+		if (typeof v === 'string') return v;
+
+		return JSON.stringify(v);
+	}
+
+	function renderEvent(ev) {
+		const r = el('div', 'event');
+
+		r.appendChild(el('span', 'time', ev._t ? ev._t.replace('T', ' ') : ''));
+
+		const lv = ev._l === undefined ? '' : (levels[ev._l] || ev._l);
+		r.appendChild(el('span', 'level level-' + lv, lv));
+
+		if (ev._c !== undefined) r.appendChild(el('span', 'caller', formatValue(ev._c)));
+
+		r.appendChild(el('span', 'msg', ev._m || ''));
+
+		for (const k in ev) {
+			if (special.includes(k)) continue;
+
+			const kv = el('span', 'kv');
+			kv.appendChild(el('span', 'key', k));
+
+			if (k === '_s' || k === '_p') {
+				const a = el('a', 'val', ev[k]);
+				a.href = '?trace=' + encodeURIComponent(ev[k]);
+				kv.appendChild(a);
+			} else {
+				kv.appendChild(el('span', 'val', formatValue(ev[k])));
+			}
+
+			r.appendChild(kv);
+		}
+
+		return r;
+	}
+
+	// buildSpans groups trace events by span and links spans to parents.
+	function buildSpans(evs) {
+		const spans = new Map();
+
+		const span = (id) => {
+			let s = spans.get(id);
+
+			if (!s) {
+				s = {id: id, name: '', start: null, end: null, children: [], events: []};
+				spans.set(id, s);
+			}
+
+			return s;
+		};
+
+		for (const ev of evs) {
+			if (!ev._s) continue;
+
+			const s = span(ev._s);
+			const t = ev._t ? Date.parse(ev._t) : null;
+
+			switch (ev._k) {
+			case 's':
+				s.name = ev._m || '';
+				s.start = t;
+				s.parent = ev._p;
+				s.attrs = ev;
+				break;
+			case 'f':
+				s.end = t;
+				if (s.start === null && t !== null && ev._e !== undefined) s.start = t - ev._e / 1e6;
+				s.finish = ev;
+				break;
+			default:
+				s.events.push(ev);
+			}
+		}
+
+		const roots = [];
+
+		for (const s of spans.values()) {
+			const ts = s.events.map((ev) => Date.parse(ev._t)).filter((t) => !isNaN(t));
+
+			if (s.start === null) s.start = Math.min(...ts);
+			if (s.end === null) s.end = Math.max(s.start, ...ts);
+
+			const par = s.parent && spans.get(s.parent);
+
+			if (par) {
+				par.children.push(s);
+			} else {
+				roots.push(s);
+			}
+		}
+
+		const byStart = (a, b) => a.start - b.start;
+
+		for (const s of spans.values()) s.children.sort(byStart);
+		roots.sort(byStart);
+
+		return roots;
+	}
+
+	function waterfall(roots) {
+		const list = el('div', 'waterfall');
+
+		let t0 = Infinity;
+		let t1 = -Infinity;
+
+		const walk = (s, f, depth) => {
+			f(s, depth);
+
+			for (const c of s.children) walk(c, f, depth + 1);
+		};
+
+		for (const r of roots) {
+			walk(r, (s) => {
+				t0 = Math.min(t0, s.start);
+				t1 = Math.max(t1, s.end);
+			}, 0);
+		}
+
+		const total = Math.max(t1 - t0, 1);
+		const pct = (t) => (100 * (t - t0) / total) + '%';
+
+		for (const r of roots) {
+			walk(r, (s, depth) => {
+				const row = el('div', 'span-row');
+
+				const name = el('div', 'span-name', s.name || s.id.slice(0, 8));
+				name.style.paddingLeft = depth + 'em';
+				name.title = s.id;
+
+				const lane = el('div', 'span-lane');
+
+				const bar = el('div', 'span-bar' + (s.finish && s.finish.err ? ' span-err' : ''));
+				bar.style.left = pct(s.start);
+				bar.style.width = 'max(2px, ' + (100 * (s.end - s.start) / total) + '%)';
+				bar.title = (s.end - s.start).toFixed(3) + 'ms';
+
+				lane.appendChild(bar);
+
+				for (const ev of s.events) {
+					const mark = el('div', 'span-event');
+					mark.style.left = pct(Date.parse(ev._t));
+					mark.title = (ev._m || '') + ' ' + JSON.stringify(ev);
+
+					lane.appendChild(mark);
+				}
+
+				row.append(name, lane, el('div', 'span-dur', bar.title));
+
+				row.onclick = () => {
+					const next = row.nextSibling;
+
+					if (next && next.classList.contains('span-details')) {
+						next.remove();
+						return;
+					}
+
+					const details = el('div', 'span-details events');
+
+					for (const ev of [s.attrs, ...s.events, s.finish]) {
+						if (ev) details.appendChild(renderEvent(ev));
+					}
+
+					row.after(details);
+				};
+
+				list.appendChild(row);
+			}, 0);
+		}
+
+		return list;
+	}
+
+	async function traceView(main, id) {
+		const header = el('div', 'query');
+		const back = el('a', '', 'back');
+		back.href = location.pathname;
+
+		header.append(back, el('span', 'status', 'trace ' + id));
+
+		const list = el('div', '');
+		main.append(header, list);
+
+		const resp = await fetch('/v0/trace/' + encodeURIComponent(id) + '.json');
+		if (!resp.ok) {
+			list.textContent = await resp.text();
+			return;
+		}
+
+		const evs = (await resp.text()).split('\n').filter((l) => l).map((l) => JSON.parse(l));
+
+		list.appendChild(waterfall(buildSpans(evs)));
+	}
+
+	function append(list, ev) {
+		const follow = window.innerHeight + window.scrollY >= document.body.scrollHeight - 10;
+
+		list.appendChild(renderEvent(ev));
+
+		while (list.childElementCount > maxRows) list.removeChild(list.firstChild);
+
+		if (follow) window.scrollTo(0, document.body.scrollHeight);
+	}
+
+	async function loadHistory(list, q) {
+		const resp = await fetch('/v0/events.json?limit=-100', {method: 'POST', body: q});
+		if (!resp.ok) throw new Error(await resp.text());
+
+		const text = await resp.text();
+
+		for (const line of text.split('\n')) {
+			if (line) append(list, JSON.parse(line));
+		}
+	}
+
+	function tail(list, status, q) {
+		if (source) source.close();
+
+		source = new EventSource('/v0/tail?q=' + encodeURIComponent(q));
+
+		source.onopen = () => { status.textContent = 'live'; };
+		source.onerror = () => { status.textContent = 'reconnecting'; };
+		source.onmessage = (e) => append(list, JSON.parse(e.data));
+	}
+
+	function stop(status) {
+		if (source) source.close();
+
+		source = null;
+		status.textContent = 'paused';
+	}
+
+	function init() {
+		const main = document.querySelector('main');
+
+		const trace = new URLSearchParams(location.search).get('trace');
+		if (trace) {
+			traceView(main, trace);
+			return;
+		}
+
+		const form = el('form', 'query');
+		const input = el('input');
+		const run = el('button', '', 'Run');
+		const live = el('button', '', 'Pause');
+		const status = el('span', 'status', '');
+		const list = el('div', 'events');
+
+		input.type = 'search';
+		input.placeholder = 'query: {service=api} key=value text';
+		input.value = new URLSearchParams(location.search).get('q') || '';
+
+		run.type = 'submit';
+		live.type = 'button';
+
+		form.append(input, run, live, status);
+		main.append(form, list);
+
+		const start = async () => {
+			const q = input.value;
+
+			list.replaceChildren();
+			window.history.replaceState(null, '', q ? '?q=' + encodeURIComponent(q) : location.pathname);
+
+			try {
+				await loadHistory(list, q);
+			} catch (err) {
+				status.textContent = String(err);
+			}
+
+			tail(list, status, q);
+			live.textContent = 'Pause';
+		};
+
+		form.onsubmit = (e) => {
+			e.preventDefault();
+			start();
+		};
+
+		live.onclick = () => {
+			if (source) {
+				stop(status);
+				live.textContent = 'Resume';
+
+				return;
+			}
+
+			tail(list, status, input.value);
+			live.textContent = 'Pause';
+		};
+
+		start();
+	}
+
+	if (document.readyState === 'loading') {
+		document.addEventListener('DOMContentLoaded', init);
+	} else {
+		init();
+	}
 })()